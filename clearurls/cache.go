@@ -0,0 +1,99 @@
+package clearurls
+
+// Storage backends for downloaded rules, see `DownloadSource.DownloadWithCacheBackend`
+
+import (
+	"sync"
+	"time"
+)
+
+// One cached rules document, and where it came from
+type CacheEntry struct {
+	Data []byte
+	// When `Data` was downloaded, used to expire the entry
+	FetchedAt time.Time
+	// Hex SHA-256 of `Data`, checked when the entry is read back. May be empty if unknown.
+	SHA256 string
+	// As sent by the server with `Data`, if any
+	ETag string
+	// URL `Data` was downloaded from, if known
+	SourceURL string
+	// The ed25519 signature of `Data`, for sources checking one (see [DownloadSource.WithSignature])
+	Signature []byte
+	// Set by a [SnapshotCache] when serving a pinned snapshot, which never expires
+	Pinned bool
+}
+
+// Storage for downloaded rules, addressed by a key (a file name for [FileCache]).
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	// Return the entry stored as `key`, or `nil, nil` if there is none
+	Get(key string) (*CacheEntry, error)
+	// Store `entry` as `key`, replacing any previous one
+	Put(key string, entry *CacheEntry) error
+}
+
+// Optionally implemented by a [Cache] so that concurrent users of a key take turns
+// to read, and refresh it when expired
+type CacheLocker interface {
+	// Wait for and take an exclusive lock on `key`. Call `unlock` to release it.
+	Lock(key string) (unlock func() error, err error)
+}
+
+// A [Cache] in memory, for the life of the process
+type MemoryCache struct {
+	mutex   sync.Mutex
+	entries map[string]CacheEntry
+	locks   map[string]*sync.Mutex
+}
+
+// Create an empty [MemoryCache]
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries: make(map[string]CacheEntry),
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+// implements Cache
+func (cache *MemoryCache) Get(key string) (*CacheEntry, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// implements Cache
+func (cache *MemoryCache) Put(key string, entry *CacheEntry) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries[key] = *entry
+	return nil
+}
+
+// implements CacheDeleter
+func (cache *MemoryCache) Delete(key string) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.entries, key)
+	return nil
+}
+
+// implements CacheLocker
+func (cache *MemoryCache) Lock(key string) (unlock func() error, err error) {
+	cache.mutex.Lock()
+	lock := cache.locks[key]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		cache.locks[key] = lock
+	}
+	cache.mutex.Unlock()
+	lock.Lock()
+	return func() error {
+		lock.Unlock()
+		return nil
+	}, nil
+}
@@ -0,0 +1,100 @@
+package clearurls
+
+// Default location and file names to cache the rules of each `DownloadSource`
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Name of the folder created in the user's cache directory, see [DefaultCacheDir]
+const defaultCacheDirName = "clearurls-go"
+
+// Return the folder in which [DownloadSource.DownloadWithDefaultCache] stores rules:
+// `clearurls-go` in `os.UserCacheDir()` (eg: `~/.cache/clearurls-go` on linux)
+func DefaultCacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDir, defaultCacheDirName), nil
+}
+
+var unsafeCacheKeyChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// Return a file name unique to the URLs of this source, and how it checks the rules,
+// to cache it without colliding with other sources, eg: `rules2.clearurls.xyz_data.minify.json-0123456789ab.json`.
+// A source with a pinned SHA-256 or a signature doesn't share entries with one without.
+func (source *DownloadSource) CacheKey() string {
+	hash := sha256.New()
+	readable := ""
+	if source.IsMirrored() {
+		readable = "mirrors"
+		for _, mirror := range source.mirrors {
+			fmt.Fprintf(hash, "%s\n", mirror.CacheKey())
+		}
+	} else {
+		readable = source.data
+		if _, afterScheme, found := strings.Cut(readable, "://"); found {
+			readable = afterScheme
+		}
+		readable = strings.Trim(unsafeCacheKeyChars.ReplaceAllString(readable, "_"), "_")
+		if len(readable) > 64 {
+			readable = readable[:64]
+		}
+		fmt.Fprintf(hash, "%s\n%s\n", source.data, source.hash256)
+	}
+	// Only when set, so that the keys of plain sources don't change
+	if source.pinnedSHA256 != "" {
+		fmt.Fprintf(hash, "pinned %s\n", source.pinnedSHA256)
+	}
+	if source.signatureURL != "" {
+		fmt.Fprintf(hash, "signature %s %x\n", source.signatureURL, []byte(source.publicKey))
+	}
+	return fmt.Sprintf("%s-%x.json", readable, hash.Sum(nil)[:6])
+}
+
+// Return a [FileCache] in `dir`, keeping [DefaultSnapshotsKept] snapshots
+func newFileSnapshotCache(dir string) *SnapshotCache {
+	return NewSnapshotCache(&FileCache{Dir: dir}, DefaultSnapshotsKept)
+}
+
+// Return a [FileCache] in [DefaultCacheDir]
+func defaultFileCache() (*FileCache, error) {
+	dir, err := DefaultCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &FileCache{Dir: dir}, nil
+}
+
+// Same as [DownloadSource.DownloadWithCache], in the file [DownloadSource.CacheKey] of [DefaultCacheDir]
+func (source *DownloadSource) DownloadWithDefaultCache(cacheMaxAgeM int, checkHash bool) ([]RunnableProvider, error) {
+	cache, err := defaultFileCache()
+	if err != nil {
+		return nil, err
+	}
+	return source.DownloadWithCacheBackend(cache, source.CacheKey(), cacheMaxAgeM, checkHash)
+}
+
+// Same as [DownloadSource.DownloadWithDefaultCache], returning a compiled [RuleSet]
+func (source *DownloadSource) DownloadRuleSetWithDefaultCache(cacheMaxAgeM int, checkHash bool) (*RuleSet, error) {
+	cache, err := defaultFileCache()
+	if err != nil {
+		return nil, err
+	}
+	return source.DownloadRuleSetWithCache(cache, source.CacheKey(), cacheMaxAgeM, checkHash)
+}
+
+// Same as [DownloadSource.DownloadWithDefaultCache] but [Compile] the providers before returning
+func (source *DownloadSource) DownloadWithDefaultCacheCompiled(cacheMaxAgeM int, checkHash bool) ([]RunnableProvider, error) {
+	result, err := source.DownloadWithDefaultCache(cacheMaxAgeM, checkHash)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(result)
+}
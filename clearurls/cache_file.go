@@ -0,0 +1,165 @@
+package clearurls
+
+// File system `Cache`, one file per key, with its metadata in files next to it
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Extension of the file next to a cache file, holding the hex SHA-256 of its content
+	cacheChecksumExtension = ".sha256"
+	// Extension of the file next to a cache file, holding the rest of its metadata as JSON
+	cacheMetadataExtension = ".meta.json"
+	// Extension of the file next to a cache file, locked while it is used
+	cacheLockExtension = ".lock"
+)
+
+// Metadata stored in the `.meta.json` file next to a cache file
+type fileCacheMetadata struct {
+	ETag      string `json:"etag,omitempty"`
+	SourceURL string `json:"sourceURL,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// A [Cache] storing each key as the file `Dir/key`, as done by [DownloadSource.DownloadWithCache].
+//
+//   - The file's modification time is the time it was fetched
+//   - Its SHA-256 is stored in `Dir/key.sha256`, and its ETag, source URL and signature in `Dir/key.meta.json`
+//   - Files are replaced atomically, so they are never seen partially written
+//   - Processes sharing the files take turns, with an advisory lock on `Dir/key.lock`
+//
+// If `Dir` is empty, keys are file paths.
+type FileCache struct {
+	Dir string
+}
+
+func (cache *FileCache) filename(key string) string {
+	return filepath.Join(cache.Dir, key)
+}
+
+// implements Cache
+func (cache *FileCache) Get(key string) (*CacheEntry, error) {
+	filename := cache.filename(key)
+	stat, err := os.Stat(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := readOptionalFile(filename + cacheChecksumExtension)
+	if err != nil {
+		return nil, err
+	}
+	var metadata fileCacheMetadata
+	metadataJSON, err := readOptionalFile(filename + cacheMetadataExtension)
+	if err != nil {
+		return nil, err
+	}
+	if metadataJSON != "" {
+		if err := json.Unmarshal([]byte(metadataJSON), &metadata); err != nil {
+			verbose("Cache: Ignoring invalid metadata of %q (%v)", filename, err)
+		}
+	}
+	return &CacheEntry{
+		Data:      data,
+		FetchedAt: stat.ModTime(),
+		SHA256:    sum,
+		ETag:      metadata.ETag,
+		SourceURL: metadata.SourceURL,
+		Signature: metadata.Signature,
+	}, nil
+}
+
+// implements Cache
+func (cache *FileCache) Put(key string, entry *CacheEntry) error {
+	filename := cache.filename(key)
+	if err := ensureParentFolderExists(filename); err != nil {
+		return err
+	}
+	if err := writeFileAtomically(filename, entry.Data); err != nil {
+		return err
+	}
+	sum := entry.SHA256
+	if sum == "" {
+		sum = sha256Hex(entry.Data)
+	}
+	if err := writeFileAtomically(filename+cacheChecksumExtension, []byte(sum+"\n")); err != nil {
+		return err
+	}
+	metadataJSON, err := json.Marshal(&fileCacheMetadata{ETag: entry.ETag, SourceURL: entry.SourceURL, Signature: entry.Signature})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filename+cacheMetadataExtension, append(metadataJSON, '\n'))
+}
+
+// implements CacheDeleter
+func (cache *FileCache) Delete(key string) error {
+	filename := cache.filename(key)
+	for _, name := range []string{filename, filename + cacheChecksumExtension, filename + cacheMetadataExtension} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// implements CacheLocker
+//
+// Creates the parent folder of the file immediately (this helps to early check write permissions).
+func (cache *FileCache) Lock(key string) (unlock func() error, err error) {
+	filename := cache.filename(key)
+	if err := ensureParentFolderExists(filename); err != nil {
+		return nil, err
+	}
+	return lockFile(filename + cacheLockExtension)
+}
+
+// Return the trimmed content of `filename`, or an empty string if it doesn't exist
+func readOptionalFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Write `data` to `filename` through a temporary file renamed over it,
+// so that readers never see a partially written file
+func writeFileAtomically(filename string, data []byte) error {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
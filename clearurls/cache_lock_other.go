@@ -0,0 +1,9 @@
+//go:build !unix
+
+package clearurls
+
+// Advisory locks are only implemented on unix, elsewhere concurrent processes
+// rely on the atomic replacement of cache files only.
+func lockFile(filename string) (unlock func() error, err error) {
+	return func() error { return nil }, nil
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package clearurls
+
+import (
+	"os"
+	"syscall"
+)
+
+// Take an exclusive advisory lock on `filename`, creating it if needed, waiting
+// for other processes to release it. Call `unlock` to release it.
+func lockFile(filename string) (unlock func() error, err error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() error {
+		defer file.Close()
+		return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}, nil
+}
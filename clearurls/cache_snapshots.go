@@ -0,0 +1,283 @@
+package clearurls
+
+// Keep previous versions of cached rules, to pin one or roll back when upstream
+// publishes bad rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Number of snapshots kept by source arguments with a cache (see [GetProvidersFromSourceArgument])
+const DefaultSnapshotsKept = 5
+
+// Suffix of the keys under which a [SnapshotCache] stores the snapshots of a key
+const snapshotsKeySuffix = ".snapshots"
+
+// What a [SnapshotCache] knows of one snapshot
+type SnapshotInfo struct {
+	SHA256    string    `json:"sha256"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	SourceURL string    `json:"sourceURL,omitempty"`
+	// `true` if this snapshot is served instead of the latest rules, see [Snapshots.Pin]
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// Stored as the index of the snapshots of a key
+type snapshotsIndex struct {
+	Pinned    string         `json:"pinned,omitempty"`
+	Snapshots []SnapshotInfo `json:"snapshots"` // Most recent first
+}
+
+// Optionally implemented by a [Cache] to remove entries, eg: old snapshots of a [SnapshotCache]
+type CacheDeleter interface {
+	// Remove the entry stored as `key`, if any
+	Delete(key string) error
+}
+
+// A [Cache] that also keeps the last `Keep` distinct versions of each key, addressed by
+// their SHA-256, in the wrapped [Cache]. One of them can be pinned to be served instead
+// of the latest rules, see [SnapshotCache.Snapshots].
+//
+// With a [FileCache], the snapshots of `Dir/key` are in the folder `Dir/key.snapshots/`.
+type SnapshotCache struct {
+	Cache Cache
+	// Maximum number of snapshots kept for each key. Older ones are removed
+	// if `Cache` is a [CacheDeleter], and forgotten otherwise.
+	Keep int
+}
+
+// Wrap `cache` to keep `keep` snapshots of each key
+func NewSnapshotCache(cache Cache, keep int) *SnapshotCache {
+	return &SnapshotCache{Cache: cache, Keep: keep}
+}
+
+func snapshotsIndexKey(key string) string {
+	return key + snapshotsKeySuffix + "/index.json"
+}
+
+func snapshotKey(key, sum string) string {
+	return key + snapshotsKeySuffix + "/" + sum + ".json"
+}
+
+func (cache *SnapshotCache) readIndex(key string) (*snapshotsIndex, error) {
+	index := &snapshotsIndex{}
+	entry, err := cache.Cache.Get(snapshotsIndexKey(key))
+	if err != nil || entry == nil {
+		return index, err
+	}
+	if err := json.Unmarshal(entry.Data, index); err != nil {
+		return nil, fmt.Errorf("invalid snapshots index for %q: %w", key, err)
+	}
+	return index, nil
+}
+
+func (cache *SnapshotCache) writeIndex(key string, index *snapshotsIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return cache.Cache.Put(snapshotsIndexKey(key), &CacheEntry{Data: data, FetchedAt: time.Now()})
+}
+
+// implements Cache
+//
+// Returns the pinned snapshot of `key` if there is one, marked as [CacheEntry.Pinned].
+func (cache *SnapshotCache) Get(key string) (*CacheEntry, error) {
+	index, err := cache.readIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	if index.Pinned == "" {
+		return cache.Cache.Get(key)
+	}
+	entry, err := cache.Cache.Get(snapshotKey(key, index.Pinned))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("pinned snapshot %s of %q is missing", index.Pinned, key)
+	}
+	entry.Pinned = true
+	return entry, nil
+}
+
+// implements Cache
+//
+// Also stores `entry` as a snapshot, unless it is the same as the latest one.
+func (cache *SnapshotCache) Put(key string, entry *CacheEntry) error {
+	if err := cache.Cache.Put(key, entry); err != nil {
+		return err
+	}
+	sum := entry.SHA256
+	if sum == "" {
+		sum = sha256Hex(entry.Data)
+	}
+	index, err := cache.readIndex(key)
+	if err != nil {
+		return err
+	}
+	if len(index.Snapshots) > 0 && index.Snapshots[0].SHA256 == sum {
+		return nil
+	}
+	if err := cache.Cache.Put(snapshotKey(key, sum), entry); err != nil {
+		return err
+	}
+	snapshots := []SnapshotInfo{{SHA256: sum, FetchedAt: entry.FetchedAt, SourceURL: entry.SourceURL}}
+	for _, snapshot := range index.Snapshots {
+		if snapshot.SHA256 == sum {
+			continue // Moved to the front
+		}
+		if len(snapshots) < cache.Keep || snapshot.SHA256 == index.Pinned {
+			snapshots = append(snapshots, snapshot)
+		} else if deleter, ok := cache.Cache.(CacheDeleter); ok {
+			if err := deleter.Delete(snapshotKey(key, snapshot.SHA256)); err != nil {
+				return err
+			}
+		}
+	}
+	index.Snapshots = snapshots
+	return cache.writeIndex(key, index)
+}
+
+// implements CacheLocker, if the wrapped [Cache] does
+func (cache *SnapshotCache) Lock(key string) (unlock func() error, err error) {
+	if locker, ok := cache.Cache.(CacheLocker); ok {
+		return locker.Lock(key)
+	}
+	return func() error { return nil }, nil
+}
+
+// Return the snapshots of `key`, to list, pin, or roll back
+func (cache *SnapshotCache) Snapshots(key string) *Snapshots {
+	return &Snapshots{cache: cache, key: key}
+}
+
+// The snapshots of one key of a [SnapshotCache]
+type Snapshots struct {
+	cache *SnapshotCache
+	key   string
+}
+
+// Run `update` on the index of the snapshots, holding the lock of the key if any
+func (snapshots *Snapshots) updateIndex(update func(index *snapshotsIndex) error) error {
+	unlock, err := snapshots.cache.Lock(snapshots.key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	index, err := snapshots.cache.readIndex(snapshots.key)
+	if err != nil {
+		return err
+	}
+	if err := update(index); err != nil {
+		return err
+	}
+	return snapshots.cache.writeIndex(snapshots.key, index)
+}
+
+// Return the snapshots, most recent first
+func (snapshots *Snapshots) List() ([]SnapshotInfo, error) {
+	index, err := snapshots.cache.readIndex(snapshots.key)
+	if err != nil {
+		return nil, err
+	}
+	for i := range index.Snapshots {
+		index.Snapshots[i].Pinned = index.Snapshots[i].SHA256 == index.Pinned
+	}
+	return index.Snapshots, nil
+}
+
+// Find the snapshot whose SHA-256 is or starts with `sum`
+func findSnapshot(index *snapshotsIndex, key, sum string) (*SnapshotInfo, error) {
+	var found *SnapshotInfo
+	sum = strings.ToLower(sum)
+	for i, snapshot := range index.Snapshots {
+		if sum != "" && strings.HasPrefix(snapshot.SHA256, sum) {
+			if found != nil {
+				return nil, fmt.Errorf("ambiguous snapshot %q of %q", sum, key)
+			}
+			found = &index.Snapshots[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no snapshot %q of %q", sum, key)
+	}
+	return found, nil
+}
+
+// Return the snapshot whose SHA-256 is or starts with `sum`
+func (snapshots *Snapshots) Get(sum string) (*CacheEntry, error) {
+	index, err := snapshots.cache.readIndex(snapshots.key)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := findSnapshot(index, snapshots.key, sum)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := snapshots.cache.Cache.Get(snapshotKey(snapshots.key, snapshot.SHA256))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("snapshot %s of %q is missing", snapshot.SHA256, snapshots.key)
+	}
+	entry.Pinned = snapshot.SHA256 == index.Pinned
+	return entry, nil
+}
+
+// Serve the snapshot whose SHA-256 is or starts with `sum` instead of the latest rules,
+// until [Snapshots.Unpin]. Returns the pinned snapshot.
+func (snapshots *Snapshots) Pin(sum string) (*SnapshotInfo, error) {
+	var pinned SnapshotInfo
+	err := snapshots.updateIndex(func(index *snapshotsIndex) error {
+		snapshot, err := findSnapshot(index, snapshots.key, sum)
+		if err != nil {
+			return err
+		}
+		index.Pinned = snapshot.SHA256
+		pinned = *snapshot
+		pinned.Pinned = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pinned, nil
+}
+
+// Serve the latest rules again
+func (snapshots *Snapshots) Unpin() error {
+	return snapshots.updateIndex(func(index *snapshotsIndex) error {
+		index.Pinned = ""
+		return nil
+	})
+}
+
+// Pin the snapshot preceding the one currently served (the pinned one, or else the latest).
+// Returns the pinned snapshot.
+func (snapshots *Snapshots) Rollback() (*SnapshotInfo, error) {
+	var pinned SnapshotInfo
+	err := snapshots.updateIndex(func(index *snapshotsIndex) error {
+		current := 0
+		for i, snapshot := range index.Snapshots {
+			if snapshot.SHA256 == index.Pinned {
+				current = i
+			}
+		}
+		if current+1 >= len(index.Snapshots) {
+			return fmt.Errorf("no snapshot of %q older than the one served to roll back to", snapshots.key)
+		}
+		pinned = index.Snapshots[current+1]
+		pinned.Pinned = true
+		index.Pinned = pinned.SHA256
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pinned, nil
+}
@@ -0,0 +1,147 @@
+// Helpers to test code using clearurls offline, with a local server standing in for
+// the ClearURLs rules mirrors.
+//
+// Example:
+//
+//	server := clearurlstest.NewServer(clearurlstest.MinimalRules, clearurlstest.Options{})
+//	defer server.Close()
+//	providers, err := server.Source().DownloadCompiled(true)
+//	// ...
+//	server.SetOptions(clearurlstest.Options{StatusCode: http.StatusServiceUnavailable})
+//	_, err = server.Source().Download(true) // Fails with a *clearurls.HTTPStatusError
+package clearurlstest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/ddlsmurf/clearurls-go/clearurls"
+)
+
+// Paths the rules and their hash are served at
+const (
+	RulesPath = "/data.minify.json"
+	HashPath  = "/rules.minify.hash"
+)
+
+// A small valid rules document, with one provider removing `utm_*` parameters from
+// `example.com` and `test` as a global rule, with inline tests
+var MinimalRules = []byte(`{
+  "providers": {
+    "example": {
+      "urlPattern": "^https?:\\/\\/(?:[a-z0-9-]+\\.)*?example\\.com",
+      "completeProvider": false,
+      "rules": ["utm_[a-z]+"],
+      "referralMarketing": ["ref"],
+      "exceptions": ["^https?:\\/\\/(?:[a-z0-9-]+\\.)*?example\\.com\\/keep"],
+      "redirections": ["^https?:\\/\\/(?:[a-z0-9-]+\\.)*?example\\.com\\/redirect\\?to=([^&]*)"],
+      "tests": [
+        {"input": "https://example.com/?utm_source=a&q=1", "expected": "https://example.com/?q=1"},
+        {"input": "https://example.com/keep?utm_source=a", "expected": "https://example.com/keep?utm_source=a"}
+      ]
+    },
+    "globalRules": {
+      "urlPattern": ".*",
+      "completeProvider": false,
+      "rules": ["test"]
+    }
+  }
+}`)
+
+// How a [Server] misbehaves, the zero value serves valid rules
+type Options struct {
+	// Serve a hash that does not match the rules
+	WrongHash bool
+	// Serve the rules with this `Content-Type` instead of `application/json`
+	ContentType string
+	// Wait this long before answering each request
+	Delay time.Duration
+	// Answer all requests with this status and no body, eg: `http.StatusServiceUnavailable`
+	StatusCode int
+}
+
+// A local HTTP server serving a rules document and its hash, see [NewServer]
+type Server struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	rules    []byte
+	options  Options
+	requests map[string]int
+}
+
+// Start a server serving `rules` at [RulesPath] and their SHA-256 at [HashPath],
+// misbehaving as per `options`. Call [Server.Close] when done.
+func NewServer(rules []byte, options Options) *Server {
+	server := &Server{rules: rules, options: options, requests: map[string]int{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	return server
+}
+
+// Return a source downloading from this server
+func (server *Server) Source() *clearurls.DownloadSource {
+	return clearurls.NewDownloadSource(server.URL+RulesPath, server.URL+HashPath)
+}
+
+// Serve `rules` from now on, eg: to test cache expiry
+func (server *Server) SetRules(rules []byte) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.rules = rules
+}
+
+// Misbehave as per `options` from now on
+func (server *Server) SetOptions(options Options) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.options = options
+}
+
+// Number of requests received for `path`, eg: [RulesPath] to check a cache was used
+func (server *Server) Requests(path string) int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.requests[path]
+}
+
+func (server *Server) serveHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mutex.Lock()
+	rules, options := server.rules, server.options
+	server.requests[request.URL.Path]++
+	server.mutex.Unlock()
+
+	if options.Delay > 0 {
+		select {
+		case <-time.After(options.Delay):
+		case <-request.Context().Done():
+			return
+		}
+	}
+	if options.StatusCode != 0 {
+		writer.WriteHeader(options.StatusCode)
+		return
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256(rules))
+	switch request.URL.Path {
+	case RulesPath:
+		contentType := options.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		writer.Header().Set("Content-Type", contentType)
+		writer.Header().Set("ETag", `"`+sum+`"`)
+		writer.Write(rules)
+	case HashPath:
+		if options.WrongHash {
+			sum = fmt.Sprintf("%x", sha256.Sum256(append([]byte("wrong"), rules...)))
+		}
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.Write([]byte(sum + "\n"))
+	default:
+		http.NotFound(writer, request)
+	}
+}
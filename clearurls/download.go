@@ -1,6 +1,6 @@
 package clearurls
 
-// Handle HTTP, and file cache aspects of obtaining the raw JSON from ClearURLs distribution
+// Handle HTTP, and cache aspects of obtaining the raw JSON from ClearURLs distribution
 
 import (
 	"fmt"
@@ -37,82 +37,108 @@ func verbose(format string, a ...any) {
 	}
 }
 
-// Gets the return of running `miss()` using a cache file `cacheRootFolder/cacheFileName`.
+// Gets the return of running `miss()` using the entry `key` of `cache`.
 //
-// Will create the folder `cacheRootFolder` even if `miss()` fails, leaving it
-// empty (this helps to early check write permissions).
-func getCachedData(cacheFileName string, cacheMaxAgeM int, miss func() ([]byte, error)) ([]byte, error) {
-	if err := ensureParentFolderExists(cacheFileName); err != nil {
-		return nil, err
-	}
-	filename := filepath.Join(cacheFileName)
-	stat, statErr := os.Stat(filename)
-	var bytesFromCache []byte
-	if statErr != nil {
-		if !os.IsNotExist(statErr) {
-			return nil, statErr
+// If `cache` is a [CacheLocker], the whole process holds the lock of `key`, so that
+// concurrent users of the cache don't refresh it at the same time.
+// A cached entry not matching its SHA-256, or not passing `validate`, is considered absent.
+// `fromCache` is `false` if `miss()` was used.
+func getCachedData(cache Cache, key string, cacheMaxAgeM int, miss func() (*CacheEntry, error), validate func(*CacheEntry) error) (entry *CacheEntry, fromCache bool, err error) {
+	if locker, ok := cache.(CacheLocker); ok {
+		unlock, err := locker.Lock(key)
+		if err != nil {
+			return nil, false, err
 		}
-	} else {
-		fileAge := time.Since(stat.ModTime()).Truncate(time.Second)
-		verbose("Cache: Using %q (%s old)", cacheFileName, fileAge.String())
-		var errReading error
-		bytesFromCache, errReading = os.ReadFile(filename)
-		if errReading != nil {
-			return nil, errReading
+		defer unlock()
+	}
+	entry, err = cache.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if entry != nil {
+		entryAge := time.Since(entry.FetchedAt).Truncate(time.Second)
+		verbose("Cache: Using %q (%s old)", key, entryAge.String())
+		if entry.Pinned {
+			if err := validateCacheEntry(entry, validate); err != nil {
+				return nil, false, fmt.Errorf("pinned snapshot of %q: %w", key, err)
+			}
+			verbose("Cache: Pinned snapshot %s", entry.SHA256)
+			return entry, true, nil
 		}
-		if cacheMaxAgeM < 1 || cacheMaxAgeM > int(fileAge.Minutes()) {
-			return bytesFromCache, nil
+		if err := validateCacheEntry(entry, validate); err != nil {
+			verbose("Cache: Corrupt (%v) - re-downloading %q", err, key)
+		} else if cacheMaxAgeM < 1 || cacheMaxAgeM > int(entryAge.Minutes()) {
+			return entry, true, nil
 		} else {
-			verbose("Cache: Expired - re-downloading %q", cacheFileName)
+			verbose("Cache: Expired - re-downloading %q", key)
 		}
 	}
-	text, err := miss()
+	entry, err = miss()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	err = os.WriteFile(filename, []byte(text), 0644)
-	if err != nil {
-		return nil, err
+	if err := cache.Put(key, entry); err != nil {
+		return nil, false, err
+	}
+	return entry, false, nil
+}
+
+// Check `entry` against its SHA-256 if known, then with `validate`
+func validateCacheEntry(entry *CacheEntry, validate func(*CacheEntry) error) error {
+	if entry.SHA256 != "" {
+		if sum := sha256Hex(entry.Data); !strings.EqualFold(sum, entry.SHA256) {
+			return fmt.Errorf("checksum %s, stored %q", sum, truncateForError(entry.SHA256))
+		}
 	}
-	return text, nil
+	return validate(entry)
 }
 
-// Download `url` with `GET`, check `Content-Type` matches `expectedMIME` and return the body
-func getHTTPBody(url, expectedMIME string) ([]byte, error) {
+// Prefix of URLs read from the local file system instead of downloaded
+const fileURLPrefix = "file://"
+
+// Download `url` with `GET`, check `Content-Type` matches `expectedMIME` and return the body and its ETag.
+// A `file://` URL is read from the local file system, without any `Content-Type` or ETag.
+func getHTTPBody(url, expectedMIME string) ([]byte, string, error) {
+	if path, isFile := strings.CutPrefix(url, fileURLPrefix); isFile {
+		verbose("Reading %q", path)
+		data, err := os.ReadFile(filepath.FromSlash(path))
+		return data, "", err
+	}
 	var client http.Client
 	verbose("GET %q started", url)
 	resp, err := client.Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to GET %q: %v", url, err)
+		return nil, "", fmt.Errorf("failed to GET %q: %w", truncateForError(url), err)
 	}
 	defer resp.Body.Close()
 	verbose("    %q ended with %d", url, resp.StatusCode)
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to GET %q: unexpected response code %d", url, resp.StatusCode)
+		return nil, "", &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
 	}
 	if !strings.HasPrefix(resp.Header.Get("Content-Type"), expectedMIME) {
-		return nil, fmt.Errorf("failed to GET %q: wrong mime type (%q - expected %q)", url, resp.Header.Get("Content-Type"), expectedMIME)
+		return nil, "", &ContentTypeError{URL: url, ContentType: resp.Header.Get("Content-Type"), Expected: expectedMIME}
 	}
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return bodyBytes, nil
+	return bodyBytes, resp.Header.Get("ETag"), nil
 }
 
-func asyncGetHTTPBody(url, expectedMIME string) func() ([]byte, error) {
+func asyncGetHTTPBody(url, expectedMIME string) func() ([]byte, string, error) {
 	type getHTTPBodyFuncResponse struct {
 		data []byte
+		etag string
 		err  error
 	}
 	resultChannel := make(chan getHTTPBodyFuncResponse)
 	go func() {
-		data, err := getHTTPBody(url, expectedMIME)
-		resultChannel <- getHTTPBodyFuncResponse{data, err}
+		data, etag, err := getHTTPBody(url, expectedMIME)
+		resultChannel <- getHTTPBodyFuncResponse{data, etag, err}
 	}()
-	return func() ([]byte, error) {
+	return func() ([]byte, string, error) {
 		result := <-resultChannel
 		close(resultChannel)
-		return result.data, result.err
+		return result.data, result.etag, result.err
 	}
 }
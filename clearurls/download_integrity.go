@@ -0,0 +1,124 @@
+package clearurls
+
+// Check downloaded rules against a pinned SHA-256, or an ed25519 signature,
+// independently of the hash file served next to them
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Create a source reading the rules from the local file `path`, eg: a file distributed
+// with configuration management. There is no hash file, so use [DownloadSource.WithPinnedSHA256]
+// or [DownloadSource.WithSignature] to check it.
+func NewFileSource(path string) (*DownloadSource, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewDownloadSource(fileURLPrefix+filepath.ToSlash(absPath), ""), nil
+}
+
+// Return a copy of this source that only accepts rules with the hex SHA-256 `sum`,
+// whether downloaded or read from a cache. This is checked even when not checking
+// the hash file of the source.
+func (source *DownloadSource) WithPinnedSHA256(sum string) *DownloadSource {
+	result := source.clone()
+	result.pinnedSHA256 = strings.ToLower(strings.TrimSpace(sum))
+	return result
+}
+
+// Return a copy of this source that only accepts downloaded rules with a valid ed25519
+// signature by `publicKey`, found at `signatureURL`. It must be served as `application/octet-stream`,
+// either as the raw 64 bytes, or in hex or base64. The signature is kept with cached rules,
+// which are checked against it again when read back.
+//
+// For a source created with [NewMirroredSource], the rules served by any of its mirrors are
+// checked against this one signature.
+func (source *DownloadSource) WithSignature(signatureURL string, publicKey ed25519.PublicKey) *DownloadSource {
+	result := source.clone()
+	result.signatureURL = signatureURL
+	result.publicKey = publicKey
+	return result
+}
+
+// Copy everything but the download state
+func (source *DownloadSource) clone() *DownloadSource {
+	return &DownloadSource{
+		data:               source.data,
+		hash256:            source.hash256,
+		mirrors:            source.mirrors,
+		mirrorOptions:      source.mirrorOptions,
+		pinnedSHA256:       source.pinnedSHA256,
+		signatureURL:       source.signatureURL,
+		publicKey:          source.publicKey,
+		checkProviderTests: source.checkProviderTests,
+	}
+}
+
+// Parse an ed25519 public key written in hex or base64, eg: from configuration
+func ParseEd25519PublicKey(text string) (ed25519.PublicKey, error) {
+	key, err := decodeHexOrBase64([]byte(strings.TrimSpace(text)))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key: %d bytes instead of %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func decodeHexOrBase64(text []byte) ([]byte, error) {
+	if decoded, err := hex.DecodeString(string(text)); err == nil {
+		return decoded, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("neither hex nor base64: %w", err)
+	}
+	return decoded, nil
+}
+
+// Check `sum`, the hex SHA-256 of some rules, against the pinned one if any
+func (source *DownloadSource) checkPinnedSHA256(sum string) error {
+	if source.pinnedSHA256 != "" && !strings.EqualFold(sum, source.pinnedSHA256) {
+		return &ChecksumError{DataURL: source.data, Expected: source.pinnedSHA256, Got: sum}
+	}
+	return nil
+}
+
+// Check `signature` (as downloaded from `signatureURL`) of `data`, and return it decoded
+func (source *DownloadSource) checkSignature(data, signature []byte) ([]byte, error) {
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := decodeHexOrBase64([]byte(strings.TrimSpace(string(signature))))
+		if err != nil {
+			return nil, &SignatureError{DataURL: source.data, SignatureURL: source.signatureURL, Err: err}
+		}
+		signature = decoded
+	}
+	if len(source.publicKey) != ed25519.PublicKeySize || !ed25519.Verify(source.publicKey, data, signature) {
+		return nil, &SignatureError{DataURL: source.data, SignatureURL: source.signatureURL}
+	}
+	return signature, nil
+}
+
+// Download the signature at `signatureURL` and check it against `entry`, eg: served by a mirror
+func (source *DownloadSource) downloadSignature(entry *CacheEntry) ([]byte, error) {
+	signature, _, err := getHTTPBody(source.signatureURL, "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	signature, err = source.checkSignature(entry.Data, signature)
+	if signatureErr, ok := err.(*SignatureError); ok && signatureErr.DataURL == "" {
+		signatureErr.DataURL = entry.SourceURL
+	}
+	if err != nil {
+		return nil, err
+	}
+	verbose("    Valid signature %q", source.signatureURL)
+	return signature, nil
+}
@@ -0,0 +1,158 @@
+package clearurls
+
+// Fail over between several `DownloadSource`s serving the same rules, with retries
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How a source created with [NewMirroredSource] tries its mirrors
+type MirrorOptions struct {
+	// Query all mirrors at once and use the first valid answer, instead of one after the other
+	Parallel bool
+	// Number of additional rounds over the mirrors when they all failed
+	Retries int
+	// Wait before the first retry round, doubled for each following one
+	Backoff time.Duration
+}
+
+// Options used for `auto` and `a|b` source arguments, see [GetProvidersFromSourceArgument]
+var DefaultMirrorOptions = MirrorOptions{
+	Retries: 2,
+	Backoff: time.Second,
+}
+
+// Create a source that downloads from the first of `mirrors` to answer with valid
+// rules (and a valid checksum when it is checked), as per `options`. Rules failing the
+// pinned SHA-256, signature or tests of the mirrored source count as a failed mirror.
+//
+// Once a download succeeded, [DownloadSource.LastServedBy] tells which mirror was used.
+//
+// Example:
+//
+//	source := clearurls.NewMirroredSource(clearurls.MirrorOptions{Retries: 3, Backoff: time.Second}, clearurls.SourceGitHub, clearurls.SourceGitLab)
+//	providers, err := source.DownloadCompiled(true)
+func NewMirroredSource(options MirrorOptions, mirrors ...*DownloadSource) *DownloadSource {
+	return &DownloadSource{
+		mirrors:       mirrors,
+		mirrorOptions: options,
+	}
+}
+
+// `true` if this source was created with [NewMirroredSource]
+func (source *DownloadSource) IsMirrored() bool {
+	return len(source.mirrors) > 0
+}
+
+// Return the mirrors of a source created with [NewMirroredSource], or `nil`
+func (source *DownloadSource) Mirrors() []*DownloadSource {
+	return source.mirrors
+}
+
+// Return the source that served the last successful download: the source itself,
+// or for a source created with [NewMirroredSource], the mirror that answered.
+// Returns `nil` if nothing was downloaded yet.
+func (source *DownloadSource) LastServedBy() *DownloadSource {
+	return source.lastServedBy.Load()
+}
+
+// Equivalent of `downloadJSON` that tries each mirror as per `mirrorOptions`
+func (source *DownloadSource) downloadJSONFromMirrors(checkHash bool) (*CacheEntry, error) {
+	if len(source.mirrors) == 0 {
+		return nil, fmt.Errorf("no mirrors to download from")
+	}
+	tryRound := source.tryMirrorsInSequence
+	if source.mirrorOptions.Parallel {
+		tryRound = source.tryMirrorsInParallel
+	}
+	backoff := source.mirrorOptions.Backoff
+	var errs []error
+	for round := 0; ; round++ {
+		entry, roundErrs := tryRound(checkHash)
+		if roundErrs == nil {
+			return entry, nil
+		}
+		errs = roundErrs
+		if round >= source.mirrorOptions.Retries {
+			break
+		}
+		verbose("    All %d mirrors failed, retrying in %s", len(source.mirrors), backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return nil, fmt.Errorf("all %d mirrors failed: %w", len(source.mirrors), errors.Join(errs...))
+}
+
+// Download from `mirror`, checking the rules against the pinned SHA-256, signature and
+// tests of this source, so that a mirror serving stale or tampered rules fails over
+// to the next one
+func (source *DownloadSource) downloadJSONFromMirror(mirror *DownloadSource, checkHash bool) (*CacheEntry, error) {
+	entry, err := mirror.downloadJSON(checkHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := source.checkPinnedSHA256(entry.SHA256); err != nil {
+		if checksumErr, ok := err.(*ChecksumError); ok {
+			checksumErr.DataURL = entry.SourceURL
+		}
+		return nil, err
+	}
+	if source.signatureURL != "" {
+		// Whichever mirror served the rules, they must be signed by the key of this source
+		if entry.Signature, err = source.downloadSignature(entry); err != nil {
+			return nil, err
+		}
+	}
+	if err := source.checkProviderTestsOf(entry.Data); err != nil {
+		if testsErr, ok := err.(*ProviderTestsError); ok {
+			testsErr.DataURL = entry.SourceURL
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Return the rules of the first mirror to succeed, or the errors of all of them
+func (source *DownloadSource) tryMirrorsInSequence(checkHash bool) (*CacheEntry, []error) {
+	errs := make([]error, 0, len(source.mirrors))
+	for _, mirror := range source.mirrors {
+		entry, err := source.downloadJSONFromMirror(mirror, checkHash)
+		if err == nil {
+			source.lastServedBy.Store(mirror)
+			return entry, nil
+		}
+		verbose("    Mirror failed: %v", err)
+		errs = append(errs, err)
+	}
+	return nil, errs
+}
+
+// Return the rules of the first mirror to succeed, or the errors of all of them.
+// Downloads still running when one succeeds are left to finish in the background.
+func (source *DownloadSource) tryMirrorsInParallel(checkHash bool) (*CacheEntry, []error) {
+	type mirrorResponse struct {
+		mirror *DownloadSource
+		entry  *CacheEntry
+		err    error
+	}
+	responses := make(chan mirrorResponse, len(source.mirrors))
+	for _, mirror := range source.mirrors {
+		go func() {
+			entry, err := source.downloadJSONFromMirror(mirror, checkHash)
+			responses <- mirrorResponse{mirror, entry, err}
+		}()
+	}
+	errs := make([]error, 0, len(source.mirrors))
+	for range source.mirrors {
+		response := <-responses
+		if response.err == nil {
+			source.lastServedBy.Store(response.mirror)
+			return response.entry, nil
+		}
+		verbose("    Mirror failed: %v", response.err)
+		errs = append(errs, response.err)
+	}
+	return nil, errs
+}
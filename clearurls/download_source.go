@@ -3,15 +3,49 @@ package clearurls
 // Get the JSON from the web sources, validate the checksum and test it
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// Pair of URLs with a json to parse and hash to check
+// Pair of URLs with a json to parse and hash to check,
+// or a list of mirrors to fail over between (see [NewMirroredSource])
 type DownloadSource struct {
-	data, hash256 string
+	data, hash256      string
+	mirrors            []*DownloadSource
+	mirrorOptions      MirrorOptions
+	pinnedSHA256       string // See [DownloadSource.WithPinnedSHA256]
+	signatureURL       string // See [DownloadSource.WithSignature]
+	publicKey          ed25519.PublicKey
+	checkProviderTests bool // See [DownloadSource.WithProviderTests]
+	lastServedBy       atomic.Pointer[DownloadSource]
+}
+
+// Create a source for a mirror of the ClearURLs rules, eg: an internal artifact server.
+//
+// `dataURL` must serve the rules JSON as `application/json`, and `hashURL` the hex SHA-256
+// of it as `application/octet-stream`. If `hashURL` is empty, the hash is never checked.
+// Either can be a `file://` URL to read a local file instead (see also [NewFileSource]).
+//
+// The hash file comes from the same place as the rules, to also protect against a
+// compromised mirror, see [DownloadSource.WithPinnedSHA256] and [DownloadSource.WithSignature].
+//
+// To use it by name in [GetProvidersFromSourceArgument], see [RegisterSource].
+func NewDownloadSource(dataURL, hashURL string) *DownloadSource {
+	return &DownloadSource{data: dataURL, hash256: hashURL}
+}
+
+// URL of the rules JSON, empty for a source created with [NewMirroredSource]
+func (source *DownloadSource) DataURL() string {
+	return source.data
+}
+
+// URL of the SHA-256 of the rules JSON, or empty if there is none
+func (source *DownloadSource) HashURL() string {
+	return source.hash256
 }
 
 // URLs for distributed versions based on https://docs.clearurls.xyz/1.27.3/specs/rules/
@@ -25,46 +59,107 @@ var (
 		data:    "https://rules1.clearurls.xyz/data.minify.json",
 		hash256: "https://rules1.clearurls.xyz/rules.minify.hash",
 	}
+	// Fails over from [SourceGitHub] to [SourceGitLab], with [DefaultMirrorOptions]
+	SourceAuto = NewMirroredSource(DefaultMirrorOptions, SourceGitHub, SourceGitLab)
 )
 
-func (source *DownloadSource) downloadJSON(checkHash bool) ([]byte, error) {
+// Download and validate the rules, returned as a fresh [CacheEntry]
+func (source *DownloadSource) downloadJSON(checkHash bool) (*CacheEntry, error) {
+	if source.IsMirrored() {
+		return source.downloadJSONFromMirrors(checkHash)
+	}
 	bodyResponseReader := asyncGetHTTPBody(source.data, "application/json")
+	var signatureResponseReader func() ([]byte, string, error)
+	if source.signatureURL != "" {
+		signatureResponseReader = asyncGetHTTPBody(source.signatureURL, "application/octet-stream")
+	}
+	if checkHash && source.hash256 == "" {
+		verbose("    No hash URL for %q, not checking hash", source.data)
+		checkHash = false
+	}
 	expectedSHA256Str := ""
 	if checkHash {
-		hashTextBytes, err := asyncGetHTTPBody(source.hash256, "application/octet-stream")()
+		hashTextBytes, _, err := asyncGetHTTPBody(source.hash256, "application/octet-stream")()
 		if err != nil {
 			return nil, err
 		}
 		expectedSHA256Str = strings.TrimSpace(string(hashTextBytes))
 	}
-	jsonData, err := bodyResponseReader()
+	jsonData, etag, err := bodyResponseReader()
 	if err != nil {
 		return nil, err
 	}
+	sum := sha256Hex(jsonData)
 	if checkHash {
-		sum := fmt.Sprintf("%x", sha256.Sum256(jsonData))
 		if !strings.EqualFold(sum, expectedSHA256Str) {
-			return nil, fmt.Errorf(
-				"invalid checksum for %q (against %q):\n"+
-					"  expected: %q\n"+
-					"       got: %q\n",
-				source.data, source.hash256, expectedSHA256Str, sum,
-			)
+			return nil, &ChecksumError{DataURL: source.data, HashURL: source.hash256, Expected: expectedSHA256Str, Got: sum}
 		}
 		verbose("    Valid hash %q at %s", expectedSHA256Str, time.Now().Format(time.RFC3339))
 	}
-	testParsed := parseJSON(jsonData)
-	if len(testParsed) == 0 {
-		return nil, fmt.Errorf("Invalid JSON, no providers found in %q", string(jsonData))
+	if err := source.checkPinnedSHA256(sum); err != nil {
+		return nil, err
+	}
+	var signature []byte
+	if signatureResponseReader != nil {
+		signature, _, err = signatureResponseReader()
+		if err != nil {
+			return nil, err
+		}
+		if signature, err = source.checkSignature(jsonData, signature); err != nil {
+			return nil, err
+		}
+		verbose("    Valid signature %q", source.signatureURL)
+	}
+	testParsed, err := parseJSON(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules from %q: %w", truncateForError(source.data), err)
 	}
 	if _, err := Compile(testParsed); err != nil {
-		return nil, fmt.Errorf("Invalid JSON, %w in %q", err, string(jsonData))
+		return nil, fmt.Errorf("invalid rules from %q: %w", truncateForError(source.data), err)
 	}
-	return jsonData, nil
+	if err := source.checkProviderTestsOf(jsonData); err != nil {
+		return nil, err
+	}
+	source.lastServedBy.Store(source)
+	return &CacheEntry{
+		Data:      jsonData,
+		FetchedAt: time.Now(),
+		SHA256:    sum,
+		ETag:      etag,
+		SourceURL: source.data,
+		Signature: signature,
+	}, nil
 }
 
-func (source *DownloadSource) cachedDownloadJSON(cacheFileName string, cacheMaxAgeM int, checkHash bool) ([]byte, error) {
-	return getCachedData(cacheFileName, cacheMaxAgeM, func() ([]byte, error) {
+func (source *DownloadSource) cachedDownloadJSON(cache Cache, key string, cacheMaxAgeM int, checkHash bool) (*CacheEntry, bool, error) {
+	return getCachedData(cache, key, cacheMaxAgeM, func() (*CacheEntry, error) {
 		return source.downloadJSON(checkHash)
-	})
+	}, source.validateCachedEntry)
+}
+
+// Check rules read from a cache against the pinned SHA-256, their stored signature, and their
+// own tests if requested, and if their SHA-256 is unknown, that they can be parsed
+func (source *DownloadSource) validateCachedEntry(entry *CacheEntry) error {
+	if err := source.checkPinnedSHA256(sha256Hex(entry.Data)); err != nil {
+		return err
+	}
+	if source.signatureURL != "" {
+		// Checked against the signature stored with the entry, without downloading it again
+		if _, err := source.checkSignature(entry.Data, entry.Signature); err != nil {
+			return err
+		}
+	}
+	if err := source.checkProviderTestsOf(entry.Data); err != nil {
+		return err
+	}
+	if entry.SHA256 == "" {
+		_, err := parseJSON(entry.Data)
+		return err
+	}
+	return nil
+}
+
+// Return the lowercase hex SHA-256 of `data`
+func sha256Hex(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
 }
@@ -0,0 +1,127 @@
+package clearurls
+
+// Errors returned when obtaining or validating rules, to be checked with
+// `errors.Is` and `errors.As`
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// The rules were parsed, but contain no providers
+	ErrNoProviders = errors.New("no providers found")
+	// The rules are not valid JSON
+	ErrInvalidJSON = errors.New("invalid JSON")
+)
+
+// Values from the network are cut to this many bytes in error messages
+const maxErrorValueLength = 128
+
+// Shorten `value` to at most `maxErrorValueLength` bytes for inclusion in an error message
+func truncateForError(value string) string {
+	if len(value) <= maxErrorValueLength {
+		return value
+	}
+	return value[:maxErrorValueLength] + "…"
+}
+
+// A download answered with a status other than `200 OK`
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (err *HTTPStatusError) Error() string {
+	return fmt.Sprintf("failed to GET %q: unexpected response code %d", truncateForError(err.URL), err.StatusCode)
+}
+
+// A download answered with an unexpected `Content-Type`
+type ContentTypeError struct {
+	URL         string
+	ContentType string
+	Expected    string
+}
+
+func (err *ContentTypeError) Error() string {
+	return fmt.Sprintf("failed to GET %q: wrong mime type (%q - expected %q)", truncateForError(err.URL), truncateForError(err.ContentType), err.Expected)
+}
+
+// The SHA-256 of the downloaded rules does not match the expected one
+type ChecksumError struct {
+	DataURL  string
+	HashURL  string // Empty if `Expected` was pinned, see [DownloadSource.WithPinnedSHA256]
+	Expected string // As found at `HashURL`, or pinned
+	Got      string // Hex SHA-256 of what was found at `DataURL`
+}
+
+func (err *ChecksumError) Error() string {
+	if err.HashURL == "" {
+		return fmt.Sprintf(
+			"invalid checksum for %q (pinned):\n"+
+				"  expected: %q\n"+
+				"       got: %q\n",
+			truncateForError(err.DataURL), truncateForError(err.Expected), err.Got,
+		)
+	}
+	return fmt.Sprintf(
+		"invalid checksum for %q (against %q):\n"+
+			"  expected: %q\n"+
+			"       got: %q\n",
+		truncateForError(err.DataURL), truncateForError(err.HashURL), truncateForError(err.Expected), err.Got,
+	)
+}
+
+// The ed25519 signature of the downloaded rules is missing or invalid, see [DownloadSource.WithSignature]
+type SignatureError struct {
+	DataURL      string
+	SignatureURL string
+	Err          error // Why the signature could not be read, `nil` if it just doesn't match
+}
+
+func (err *SignatureError) Error() string {
+	if err.Err != nil {
+		return fmt.Sprintf("invalid signature for %q (from %q): %s", truncateForError(err.DataURL), truncateForError(err.SignatureURL), truncateForError(err.Err.Error()))
+	}
+	return fmt.Sprintf("invalid signature for %q (from %q)", truncateForError(err.DataURL), truncateForError(err.SignatureURL))
+}
+
+func (err *SignatureError) Unwrap() error {
+	return err.Err
+}
+
+// Failures listed in the message of a [ProviderTestsError], the others are only counted
+const maxErrorTestFailures = 10
+
+// Examples in the `tests` field of providers failed, see [DownloadSource.WithProviderTests]
+type ProviderTestsError struct {
+	DataURL string
+	// All of them, even if the message lists only the first few
+	Failures []ProviderTestFailure
+}
+
+func (err *ProviderTestsError) Error() string {
+	message := fmt.Sprintf("%d provider tests failed for %q", len(err.Failures), truncateForError(err.DataURL))
+	for _, failure := range err.Failures[:min(len(err.Failures), maxErrorTestFailures)] {
+		message += "\n  " + failure.String()
+	}
+	if len(err.Failures) > maxErrorTestFailures {
+		message += fmt.Sprintf("\n  and %d more", len(err.Failures)-maxErrorTestFailures)
+	}
+	return message
+}
+
+// A regex of a provider failed to compile
+type CompileError struct {
+	Provider string
+	Field    string // Name of the field in the ClearURLs JSON, eg: `urlPattern` or `redirections`
+	Err      error
+}
+
+func (err *CompileError) Error() string {
+	return fmt.Sprintf("provider %q: invalid %s: %s", err.Provider, err.Field, truncateForError(err.Err.Error()))
+}
+
+func (err *CompileError) Unwrap() error {
+	return err.Err
+}
@@ -11,12 +11,34 @@
 //
 //     - Downloaded with a local cache (see [DownloadSource.DownloadWithCacheCompiled])
 //
+//     - Downloaded with any other [Cache], eg: a [MemoryCache] (see [DownloadSource.DownloadWithCacheBackendCompiled])
+//
+//     - Download from an internal mirror (see [NewDownloadSource] and [RegisterSource])
+//
 //     - Obtain by parsing a descriptive string, convenient for configurations (see [GetProvidersFromSourceArgument])
 //
 //     - If `go generate` was ran in this package, it includes a hardcoded version (see [clearurls.MustHaveHardcodedProviders])
 //
+//     Each of those can also return a [RuleSet], with metadata on where and when the rules
+//     were obtained (see [GetRuleSetFromSourceArgument] or [HardcodedRuleSet])
+//
+//     - To review what changed between two versions of the rules, see [DiffRuleSets]
+//
+//     - To review which URLs of a sample are cleaned differently by two versions of the rules, see [MeasureImpact]
+//
+//     - To find tracking parameters the rules miss in a corpus of URLs, see [SuggestRules]
+//
+//     - To check custom rules for mistakes, see [LintRules]. Providers can carry examples in a `tests` field (see [ProviderTest])
+//
+//     - To tweak upstream providers without forking the rules JSON, see [Overlay]
+//
 //  2. For each URL to clean, call [clearurls.ClearURL]. If the result is an empty string and no error,
-//     the URL is just completely blocked.
+//     the URL is just completely blocked. To memoize results of frequently seen URLs,
+//     use a [CachedCleaner] instead.
+//     To find out which providers changed an URL, use [ClearURLWithTrace], or to count which rules fire, a [StatsCollector].
+//     To report the tracking an URL contains without cleaning it, use [AnalyzeURL], or to mask it, [ClearURLRedacted].
+//     To keep some keys, leave some hosts as is, or keep referral marketing only on some hosts, see [ClearURLWithOptions] and [Overrides].
+//     To check results against a shared corpus of expected ones, see [RunURLFixtures] and [RecordURLFixtures].
 //
 // [ClearURLs]: https://docs.clearurls.xyz/1.27.3/
 // [source]: https://github.com/ClearURLs/Addon
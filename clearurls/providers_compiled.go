@@ -22,11 +22,15 @@ type providerCompiled struct {
 
 // implements RunnableProvider
 func (provider *providerWithPreparedRegexStr) compile() (*providerCompiled, error) {
-	compileRegexpIfNotEmpty := func(rxStr string) (*regexp.Regexp, error) {
+	compileRegexpIfNotEmpty := func(field, rxStr string) (*regexp.Regexp, error) {
 		if rxStr == "" {
 			return nil, nil
 		}
-		return regexp.Compile(rxStr)
+		rx, err := regexp.Compile(rxStr)
+		if err != nil {
+			return nil, &CompileError{Provider: provider.name, Field: field, Err: err}
+		}
+		return rx, nil
 	}
 	result := &providerCompiled{
 		// ForceRedirection
@@ -34,31 +38,31 @@ func (provider *providerWithPreparedRegexStr) compile() (*providerCompiled, erro
 		name:             provider.name,
 		CompleteProvider: provider.CompleteProvider,
 	}
-	rx, err := compileRegexpIfNotEmpty(provider.URLPattern)
+	rx, err := compileRegexpIfNotEmpty("urlPattern", provider.URLPattern)
 	if err != nil {
 		return nil, err
 	}
 	result.URLPattern = rx
 
-	rx, err = compileRegexpIfNotEmpty(provider.Rules)
+	rx, err = compileRegexpIfNotEmpty("rules", provider.Rules)
 	if err != nil {
 		return nil, err
 	}
 	result.Rules = rx
 
-	rx, err = compileRegexpIfNotEmpty(provider.RawRules)
+	rx, err = compileRegexpIfNotEmpty("rawRules", provider.RawRules)
 	if err != nil {
 		return nil, err
 	}
 	result.RawRules = rx
 
-	rx, err = compileRegexpIfNotEmpty(provider.Exceptions)
+	rx, err = compileRegexpIfNotEmpty("exceptions", provider.Exceptions)
 	if err != nil {
 		return nil, err
 	}
 	result.Exceptions = rx
 
-	rx, err = compileRegexpIfNotEmpty(provider.ReferralMarketing)
+	rx, err = compileRegexpIfNotEmpty("referralMarketing", provider.ReferralMarketing)
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +70,7 @@ func (provider *providerWithPreparedRegexStr) compile() (*providerCompiled, erro
 
 	result.Redirections = make([]*regexp.Regexp, len(provider.Redirections))
 	for i, redirRXStr := range provider.Redirections {
-		rx, err = compileRegexpIfNotEmpty(redirRXStr)
+		rx, err = compileRegexpIfNotEmpty("redirections", redirRXStr)
 		if err != nil {
 			return nil, err
 		}
@@ -76,6 +80,17 @@ func (provider *providerWithPreparedRegexStr) compile() (*providerCompiled, erro
 	return result, nil
 }
 
+// Number of non-empty regexen in this provider
+func (provider *providerCompiled) regexpCount() int {
+	count := len(provider.Redirections)
+	for _, rx := range []*regexp.Regexp{provider.URLPattern, provider.Rules, provider.RawRules, provider.Exceptions, provider.ReferralMarketing} {
+		if rx != nil {
+			count++
+		}
+	}
+	return count
+}
+
 // implements RunnableProvider
 func (provider *providerCompiled) compile() (*providerCompiled, error) {
 	return provider, nil
@@ -121,3 +136,12 @@ func (source *DownloadSource) DownloadWithCacheCompiled(cacheFileName string, ca
 	}
 	return Compile(result)
 }
+
+// Same as [DownloadSource.DownloadWithCacheBackend] but [Compile] the providers before returning
+func (source *DownloadSource) DownloadWithCacheBackendCompiled(cache Cache, key string, cacheMaxAgeM int, checkHash bool) ([]RunnableProvider, error) {
+	result, err := source.DownloadWithCacheBackend(cache, key, cacheMaxAgeM, checkHash)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(result)
+}
@@ -4,10 +4,16 @@ package clearurls
 
 // implements RunnableProvider
 func (provider *providerCompiled) matchURL(url string) (bool, error) {
-	if !provider.URLPattern.MatchString(url) {
+	// A nil regex comes from an empty field: an empty `urlPattern` matches anything, empty `exceptions` nothing
+	if provider.URLPattern != nil && !provider.URLPattern.MatchString(url) {
 		return false, nil
 	}
-	return !provider.Exceptions.MatchString(url), nil
+	return provider.Exceptions == nil || !provider.Exceptions.MatchString(url), nil
+}
+
+// implements RunnableProvider
+func (provider *providerCompiled) matchURLPattern(url string) (bool, error) {
+	return provider.URLPattern == nil || provider.URLPattern.MatchString(url), nil
 }
 
 // implements RunnableProvider
@@ -41,8 +47,8 @@ func (provider *providerCompiled) applyRawRules(url string) (string, error) {
 
 // implements RunnableProvider
 func (provider *providerCompiled) rulesKeyFilter(key string, dontFilterReferrals bool) (bool, error) {
-	shouldFilter := provider.Rules.MatchString(key)
-	if dontFilterReferrals && shouldFilter && provider.ReferralMarketing.MatchString(key) {
+	shouldFilter := provider.Rules != nil && provider.Rules.MatchString(key)
+	if dontFilterReferrals && shouldFilter && provider.ReferralMarketing != nil && provider.ReferralMarketing.MatchString(key) {
 		return false, nil
 	}
 	return shouldFilter, nil
@@ -7,33 +7,88 @@ package clearurls
 import (
 	"fmt"
 	"regexp"
-	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-//go:generate go run ../tools/cleanurls/main.go generate github providers_hardcoded_data.go
+//go:generate go run ../tools/cleanurls/main.go generate github providers_hardcoded_data.go --embed-rules
 
 // This value is overwritten by the additional file created by the `go:generate` above
 var hardcodedProvidersPrepared []RunnableProvider = nil
 
-var hardcodedProvidersCompiled []RunnableProvider
+// Metadata of `hardcodedProvidersPrepared`, also overwritten by the `go:generate` above
+var hardcodedMetadata hardcodedRuleSetMetadata
+
+// The rules JSON `hardcodedProvidersPrepared` were generated from, if embedded (see
+// [GenerateGoSourceCodeForRuleSet]). Used to compare rules, not to clean URLs.
+var hardcodedRulesJSON string
+
+// What is known of the hardcoded rules at `go generate` time, times are in RFC 3339.
+// `FetchedAt` is when the rules were downloaded (or cached), so generating again from
+// the same rules only changes it, and `SHA256` is what to compare instead.
+type hardcodedRuleSetMetadata struct {
+	SHA256    string
+	SourceURL string
+	FetchedAt string
+}
+
+// Compiled once from `hardcodedProvidersPrepared`, on first use
+var hardcodedRuleSetCompiled = sync.OnceValues(func() (*RuleSet, error) {
+	if hardcodedProvidersPrepared == nil {
+		return nil, nil
+	}
+	ruleSet, err := newRuleSet(hardcodedProvidersPrepared)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet.SHA256 = hardcodedMetadata.SHA256
+	ruleSet.SourceName = hardcodedSourceName
+	ruleSet.SourceURL = hardcodedMetadata.SourceURL
+	ruleSet.FetchedAt, _ = time.Parse(time.RFC3339, hardcodedMetadata.FetchedAt)
+	if hardcodedRulesJSON != "" {
+		ruleSet.rulesJSON = []byte(hardcodedRulesJSON)
+	}
+	return ruleSet, nil
+})
 
 // If a hardcoded version was included (eg: with `go generate`), then return it.
 // Otherwise return `nil, nil`.
+//
+// Safe for concurrent use. The providers are compiled only once, and each call
+// returns its own copy of the list, so callers may reorder or modify it freely.
 func HardcodedProviders() ([]RunnableProvider, error) {
-	if hardcodedProvidersCompiled == nil {
-		if hardcodedProvidersPrepared == nil {
-			return nil, nil
-		}
-		compiled, err := Compile(hardcodedProvidersPrepared)
-		if err != nil {
-			return nil, err
-		}
-		hardcodedProvidersCompiled = compiled
+	ruleSet, err := HardcodedRuleSet()
+	if err != nil || ruleSet == nil {
+		return nil, err
+	}
+	return ruleSet.Providers, nil
+}
+
+// Same as [HardcodedProviders], returning a [RuleSet] with the metadata recorded by `go generate`
+func HardcodedRuleSet() (*RuleSet, error) {
+	ruleSet, err := hardcodedRuleSetCompiled()
+	if err != nil || ruleSet == nil {
+		return nil, err
+	}
+	return ruleSet.clone(), nil
+}
+
+// Same as [HardcodedRuleSet] but returns an error if `hardcodedProvidersPrepared` has
+// not been generated
+func MustHaveHardcodedRuleSet() (*RuleSet, error) {
+	ruleSet, err := HardcodedRuleSet()
+	if err != nil {
+		return nil, err
 	}
-	return hardcodedProvidersCompiled, nil
+	if ruleSet == nil {
+		return nil, errHardcodedNotGenerated
+	}
+	return ruleSet, nil
 }
 
+var errHardcodedNotGenerated = fmt.Errorf("Cannot load hardcoded providers, they have not been generated. Use `go generate` in this folder.")
+
 // Same as `HardcodedProviders` but returns an error if `hardcodedProvidersPrepared` has
 // not been generated
 func MustHaveHardcodedProviders() ([]RunnableProvider, error) {
@@ -42,30 +97,66 @@ func MustHaveHardcodedProviders() ([]RunnableProvider, error) {
 		return nil, err
 	}
 	if providers == nil {
-		return nil, fmt.Errorf("Cannot load hardcoded providers, they have not been generated. Use `go generate` in this folder.")
+		return nil, errHardcodedNotGenerated
 	}
 	return providers, nil
 }
 
 // Generates a .go source code with a list that can be compiled into an
 // equivalent `[]RunnableProvider` at build time. Used by `go generate`.
+//
+// The providers are kept in the order of `providers`, the order they are applied in.
 func GenerateGoSourceCodeForProviders(providers []RunnableProvider) string {
+	return generateGoSourceCode(providers, nil, nil)
+}
+
+// Same as [GenerateGoSourceCodeForProviders], also recording the metadata of `ruleSet`,
+// to be returned by [HardcodedRuleSet].
+//
+// If `embedRulesJSON`, the rules JSON is embedded too, roughly doubling the size of the
+// generated code, so that the hardcoded rule set works with [DiffRuleSets], [RuleSet.WithOverlays],
+// [NewStatsCollector] and others needing it.
+func GenerateGoSourceCodeForRuleSet(ruleSet *RuleSet, embedRulesJSON bool) string {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	metadata := &hardcodedRuleSetMetadata{
+		SHA256:    ruleSet.SHA256,
+		SourceURL: ruleSet.SourceURL,
+		FetchedAt: formatTime(ruleSet.FetchedAt),
+	}
+	var rulesJSON []byte
+	if embedRulesJSON {
+		rulesJSON = ruleSet.rulesJSON
+	}
+	return generateGoSourceCode(ruleSet.Providers, metadata, rulesJSON)
+}
+
+func generateGoSourceCode(providers []RunnableProvider, metadata *hardcodedRuleSetMetadata, rulesJSON []byte) string {
 	packageName := "clearurls"
 	lines := make([]string, len(providers))
-	packagePrefixRemover := regexp.MustCompile("^&" + packageName + "\\.")
-	slices.SortFunc(providers[:], func(i, j RunnableProvider) int {
-		return strings.Compare(strings.ToLower(i.getName()), strings.ToLower(j.getName()))
-	})
+	packagePrefixRemover := regexp.MustCompile("^&?" + packageName + "\\.")
 	for i, provider := range providers {
 		literal := fmt.Sprintf("%+#v", provider.prepare())
 		literal = packagePrefixRemover.ReplaceAllString(literal, "&")
 		lines[i] = fmt.Sprintf("\t\t%s,\n", literal)
 	}
+	metadataLine := ""
+	if metadata != nil {
+		metadataLine = "\thardcodedMetadata = " + packagePrefixRemover.ReplaceAllString(fmt.Sprintf("%#v", *metadata), "") + "\n"
+	}
+	if rulesJSON != nil {
+		metadataLine += fmt.Sprintf("\thardcodedRulesJSON = %q\n", rulesJSON)
+	}
 	return "package " + packageName + "\n\n" +
 		"// DO NOT COMMIT : Generated by ProvidersToGoSource\n\n" +
 		"func init() {\n" +
 		"\thardcodedProvidersPrepared = []RunnableProvider {\n" +
 		strings.Join(lines, "") +
 		"\t}\n" +
+		metadataLine +
 		"}\n"
 }
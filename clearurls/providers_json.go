@@ -4,8 +4,11 @@ package clearurls
 // the raw data as it came in from the JSON distribution
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 )
 
 // Unprocessed JSON source data rulesets called "providers" in ClearURL lingo
@@ -19,6 +22,8 @@ type providerJSON struct {
 	Exceptions        []string
 	Redirections      []string
 	// ForceRedirection  bool // Applies only to web
+	// Not in ClearURLs: examples checked by [RuleSet.RunProviderTests]
+	Tests []ProviderTest
 }
 
 // Debug print for `providerJSON`
@@ -47,32 +52,164 @@ func (provider *providerJSON) String() string {
 	return header + fieldCountsString + "\n"
 }
 
-// Parse the JSON into an array of `providerJSON`
-func parseJSON(jsonData []byte) []RunnableProvider {
+// Encode as in the ClearURLs JSON, with all fields parsed, eg: for generated rules
+func (provider *providerJSON) MarshalJSON() ([]byte, error) {
+	orEmpty := func(items []string) []string {
+		if items == nil {
+			return []string{}
+		}
+		return items
+	}
+	return json.Marshal(struct {
+		URLPattern        string         `json:"urlPattern"`
+		CompleteProvider  bool           `json:"completeProvider"`
+		Rules             []string       `json:"rules"`
+		RawRules          []string       `json:"rawRules"`
+		ReferralMarketing []string       `json:"referralMarketing"`
+		Exceptions        []string       `json:"exceptions"`
+		Redirections      []string       `json:"redirections"`
+		Tests             []ProviderTest `json:"tests,omitempty"`
+	}{
+		URLPattern:        provider.URLPattern,
+		CompleteProvider:  provider.CompleteProvider,
+		Rules:             orEmpty(provider.Rules),
+		RawRules:          orEmpty(provider.RawRules),
+		ReferralMarketing: orEmpty(provider.ReferralMarketing),
+		Exceptions:        orEmpty(provider.Exceptions),
+		Redirections:      orEmpty(provider.Redirections),
+		Tests:             provider.Tests,
+	})
+}
+
+// Encode `providers` as a ClearURLs rules JSON document
+func marshalRulesJSON(providers []*providerJSON) ([]byte, error) {
+	byName := make(map[string]*providerJSON, len(providers))
+	for _, provider := range providers {
+		byName[provider.name] = provider
+	}
+	return json.MarshalIndent(map[string]any{"providers": byName}, "", "  ")
+}
+
+// Parse the JSON into an array of `providerJSON`, in the order of the document.
+// Fails with [ErrInvalidJSON] or [ErrNoProviders].
+func parseJSON(jsonData []byte) ([]RunnableProvider, error) {
 	type clearURLsRoot struct {
 		Providers map[string]providerJSON
 	}
 	var parsedRules clearURLsRoot
-	json.Unmarshal(jsonData, &parsedRules)
-	providers := make([]RunnableProvider, len(parsedRules.Providers))
-	i := 0
-	for key, provider := range parsedRules.Providers {
-		provider.name = key
-		providers[i] = &provider
-		i++
-	}
-	return providers
+	if err := json.Unmarshal(jsonData, &parsedRules); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+	}
+	if len(parsedRules.Providers) == 0 {
+		return nil, ErrNoProviders
+	}
+	providers := make([]RunnableProvider, 0, len(parsedRules.Providers))
+	// In the order of the document, as the addon does, rather than the random order of the map
+	err := forEachRawProvider(jsonData, func(name string, _ json.RawMessage) error {
+		if provider, found := parsedRules.Providers[name]; found {
+			provider.name = name
+			providers = append(providers, &provider)
+			delete(parsedRules.Providers, name) // Only the last definition is used, where the first one is
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
+// Call `gotProvider` with the name and JSON of each provider of the rules JSON, in the
+// order of the document, including providers defined more than once, which
+// `encoding/json` silently merges
+func forEachRawProvider(jsonData []byte, gotProvider func(name string, provider json.RawMessage) error) error {
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	expectDelim := func(delim json.Delim) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+		}
+		if token != delim {
+			return fmt.Errorf("%w: expected %q, got %v", ErrInvalidJSON, delim, token)
+		}
+		return nil
+	}
+	// Call `gotKey` for each key of the object about to be read, which must read its value
+	forEachKey := func(gotKey func(key string) error) error {
+		if err := expectDelim('{'); err != nil {
+			return err
+		}
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+			}
+			if err := gotKey(token.(string)); err != nil {
+				return err
+			}
+		}
+		return expectDelim('}')
+	}
+	readValue := func() (json.RawMessage, error) {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+		}
+		return value, nil
+	}
+	return forEachKey(func(key string) error {
+		if !strings.EqualFold(key, "providers") {
+			_, err := readValue()
+			return err
+		}
+		return forEachKey(func(name string) error {
+			provider, err := readValue()
+			if err != nil {
+				return err
+			}
+			return gotProvider(name, provider)
+		})
+	})
+}
+
+// Same as `parseJSON`, as the definitions themselves
+func parseJSONDefinitions(jsonData []byte) ([]*providerJSON, error) {
+	providers, err := parseJSON(jsonData)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*providerJSON, len(providers))
+	for i, provider := range providers {
+		result[i] = provider.(*providerJSON)
+	}
+	return result, nil
+}
+
+// Same as `parseJSONDefinitions`, sorted by name
+func parseJSONSorted(jsonData []byte) ([]*providerJSON, error) {
+	result, err := parseJSONDefinitions(jsonData)
+	if err != nil {
+		return nil, err
+	}
+	sortDefinitionsByName(result)
+	return result, nil
+}
+
+func sortDefinitionsByName(definitions []*providerJSON) {
+	slices.SortFunc(definitions, func(a, b *providerJSON) int {
+		return strings.Compare(a.name, b.name)
+	})
 }
 
 // Download either source, optionally checking hash, does not use any cached file.
 // The returned value must have the valid hash if requested, and must be valid JSON
 // that can be compiled. This allows for caching and dealing with only valid values.
 func (source *DownloadSource) Download(checkHash bool) ([]RunnableProvider, error) {
-	data, err := source.downloadJSON(checkHash)
+	entry, err := source.downloadJSON(checkHash)
 	if err != nil {
 		return nil, err
 	}
-	return parseJSON(data), nil
+	return parseJSON(entry.Data)
 }
 
 // Download from the provided `source` (`SourceGitHub` or `SourceGitLab`) the latest rules file, and return
@@ -84,11 +221,23 @@ func (source *DownloadSource) Download(checkHash bool) ([]RunnableProvider, erro
 //   - If the file exists, and `cacheMaxAgeM` is `-1`, or is older than the file's modification time,
 //     it is read and returned as is
 //   - If the file doesn't exist, or is older than `cacheMaxAgeM`, the file is retreived as per [DownloadSource.Download]
-//   - The cache file is written with the raw json
+//   - The cache file is written with the raw json, atomically, and its SHA-256 in `cacheFileName.sha256`
+//   - A cache file not matching its SHA-256 is considered corrupt, and retreived again
+//   - Processes sharing the cache take turns, with an advisory lock on `cacheFileName.lock`
+//
+// Equivalent to [DownloadSource.DownloadWithCacheBackend] with a [FileCache]. No previous versions are
+// kept: to roll back to one, pass a [FileCache] wrapped in a [SnapshotCache] to the latter instead,
+// which keeps a full copy of each distinct version in `cacheFileName.snapshots/`.
 func (source *DownloadSource) DownloadWithCache(cacheFileName string, cacheMaxAgeM int, checkHash bool) ([]RunnableProvider, error) {
-	data, err := source.cachedDownloadJSON(cacheFileName, cacheMaxAgeM, checkHash)
+	return source.DownloadWithCacheBackend(&FileCache{}, cacheFileName, cacheMaxAgeM, checkHash)
+}
+
+// Same as [DownloadSource.DownloadWithCache], but storing the rules as `key` in any [Cache],
+// eg: a [MemoryCache] or a [FileCache]. Entries are expired based on their `FetchedAt`.
+func (source *DownloadSource) DownloadWithCacheBackend(cache Cache, key string, cacheMaxAgeM int, checkHash bool) ([]RunnableProvider, error) {
+	entry, _, err := source.cachedDownloadJSON(cache, key, cacheMaxAgeM, checkHash)
 	if err != nil {
 		return nil, err
 	}
-	return parseJSON(data), nil
+	return parseJSON(entry.Data)
 }
@@ -17,6 +17,11 @@ func (provider *providerJSON) matchURL(url string) (bool, error) {
 	return true, nil
 }
 
+// implements RunnableProvider
+func (provider *providerJSON) matchURLPattern(url string) (bool, error) {
+	return regexp.MatchString(caseInsensitiveRXStrPrefix+provider.URLPattern, url)
+}
+
 // implements RunnableProvider
 func (provider *providerJSON) getName() string {
 	return provider.name
@@ -94,6 +94,11 @@ func (provider *providerWithPreparedRegexStr) matchURL(url string) (bool, error)
 	panic(fmt.Errorf("providerWithPreparedRegexStr can't matchURL"))
 }
 
+// implements RunnableProvider - kinda
+func (provider *providerWithPreparedRegexStr) matchURLPattern(url string) (bool, error) {
+	panic(fmt.Errorf("providerWithPreparedRegexStr can't matchURLPattern"))
+}
+
 // implements RunnableProvider
 func (provider *providerWithPreparedRegexStr) getName() string {
 	return provider.name
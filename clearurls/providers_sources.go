@@ -5,18 +5,32 @@ package clearurls
 import (
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type parseSource struct {
 	sourceName    string
 	cacheFilename string
 	cacheMaxAgeM  int
+	snapshot      string
+	overlays      []string
 }
 
-// Parse arguments of the format `<source>[:<cache_filename>[:<cache_max_age_minutes>]]`
+// Start the paths of overlay files after the rest of source arguments, and separate them.
+// Longer than a single character so that paths may contain anything else, eg: `+`.
+const (
+	sourceOverlaysPrefix    = "?overlay="
+	sourceOverlaysSeparator = "&overlay="
+)
+
+// Parse arguments of the format `<source>[:<cache_filename>[:<cache_max_age_minutes>][@<snapshot_sha256>]][?overlay=<overlay_file>[&overlay=<overlay_file>...]]`
 func parseSourceArgument(source string) (*parseSource, error) {
-	matches := regexp.MustCompile("(?i)^([^:]+):?(?:(.+?)(?::(\\d*))?)?$").FindStringSubmatch(source)
+	rulesSource, overlays, hasOverlays := strings.Cut(source, sourceOverlaysPrefix)
+	// Snapshots are only parsed after a cache file, so that paths of local rules files may contain `@`
+	matches := regexp.MustCompile("(?i)^([^:]+)(?::(.+?)(?::(\\d*))?(?:@([0-9a-f]+))?)?$").FindStringSubmatch(rulesSource)
 	if matches == nil {
 		return nil, fmt.Errorf("Invalid source argument %q", source)
 	}
@@ -24,6 +38,17 @@ func parseSourceArgument(source string) (*parseSource, error) {
 		sourceName:    matches[1],
 		cacheFilename: matches[2],
 		cacheMaxAgeM:  -1,
+		snapshot:      matches[4],
+	}
+	if hasOverlays {
+		result.overlays = strings.Split(overlays, sourceOverlaysSeparator)
+		if slices.Contains(result.overlays, "") {
+			return nil, fmt.Errorf("Invalid source argument %q (empty overlay file path)", source)
+		}
+	}
+	isSnapshotWithoutCache := func(name string) bool { return strings.Contains(name, "@") && !isFileSourceName(name) }
+	if slices.ContainsFunc(strings.Split(result.sourceName, mirrorSourcesSeparator), isSnapshotWithoutCache) {
+		return nil, fmt.Errorf("Invalid source argument %q (snapshots need a cache file)", source)
 	}
 	if matches[3] != "" {
 		num, err := strconv.Atoi(matches[3])
@@ -37,25 +62,190 @@ func parseSourceArgument(source string) (*parseSource, error) {
 	return result, nil
 }
 
-var sources = map[string]*DownloadSource{
-	"github": SourceGitHub,
-	"gitlab": SourceGitLab,
+// Name reserved for [HardcodedProviders] in source arguments
+const hardcodedSourceName = "hardcoded"
+
+// Named sources usable in source arguments, see [RegisterSource]
+var (
+	sourcesMutex sync.RWMutex
+	sources      = map[string]*DownloadSource{
+		"github": SourceGitHub,
+		"gitlab": SourceGitLab,
+		"auto":   SourceAuto,
+	}
+)
+
+// Separates names of mirrors to fail over between in source arguments
+const mirrorSourcesSeparator = "|"
+
+// Cache file name in source arguments meaning [DownloadSource.DownloadWithDefaultCache]
+const defaultCacheArgument = "@"
+
+// Make `source` available as `name` in [GetProvidersFromSourceArgument], replacing any
+// source previously registered with that name. Safe for concurrent use.
+//
+// `name` must not be empty, contain a `:`, `|` or `@`, or be `hardcoded`.
+//
+// Example:
+//
+//	mirror := clearurls.NewDownloadSource("https://artifacts.corp/clearurls/data.minify.json", "https://artifacts.corp/clearurls/rules.minify.hash")
+//	err := clearurls.RegisterSource("corp-mirror", mirror)
+//	// if err != nil ....
+//	providers, err := clearurls.GetProvidersFromSourceArgument("corp-mirror:/var/cache/x.json:60")
+func RegisterSource(name string, source *DownloadSource) error {
+	if name == "" || strings.ContainsAny(name, ":@"+mirrorSourcesSeparator) || name == hardcodedSourceName {
+		return fmt.Errorf("Invalid source name %q", name)
+	}
+	if source == nil {
+		return fmt.Errorf("Invalid nil source for %q", name)
+	}
+	sourcesMutex.Lock()
+	defer sourcesMutex.Unlock()
+	sources[name] = source
+	return nil
+}
+
+// Return the source registered as `name` (see [RegisterSource]), or `nil`
+func LookupSource(name string) *DownloadSource {
+	sourcesMutex.RLock()
+	defer sourcesMutex.RUnlock()
+	return sources[name]
 }
 
-func downloadSource(source, cache string, cacheMaxAgeM int) ([]RunnableProvider, error) {
-	sourceURLs := sources[source]
-	if sourceURLs == nil {
-		return nil, fmt.Errorf("Invalid source %q", source)
+// Return the name `source` was registered with (see [RegisterSource]), or an empty string
+func registeredSourceName(source *DownloadSource) string {
+	sourcesMutex.RLock()
+	defer sourcesMutex.RUnlock()
+	for name, registered := range sources {
+		if registered == source {
+			return name
+		}
 	}
-	if cache == "" {
-		return sourceURLs.Download(true)
+	return ""
+}
+
+// `true` if `name` is not registered, but looks like the path of a local rules file,
+// eg: `./custom.json` or `rules/custom.json`
+func isFileSourceName(name string) bool {
+	return LookupSource(name) == nil && (strings.ContainsAny(name, `/\`) || strings.HasSuffix(strings.ToLower(name), ".json"))
+}
+
+// Return the source registered as `name`, or a local file source for a path (see [NewFileSource])
+func lookupSourceName(name string) (*DownloadSource, error) {
+	if isFileSourceName(name) {
+		return NewFileSource(name)
 	}
-	return sourceURLs.DownloadWithCache(cache, cacheMaxAgeM, true)
+	source := LookupSource(name)
+	if source == nil {
+		return nil, fmt.Errorf("Invalid source %q", name)
+	}
+	return source, nil
+}
+
+// Return the source named `name` (see [lookupSourceName]), or if `name` is of the format
+// `<name>|<name>...`, a source failing over between those with [DefaultMirrorOptions]
+func lookupSourceOrMirrors(name string) (*DownloadSource, error) {
+	if !strings.Contains(name, mirrorSourcesSeparator) {
+		return lookupSourceName(name)
+	}
+	names := strings.Split(name, mirrorSourcesSeparator)
+	mirrors := make([]*DownloadSource, len(names))
+	for i, mirrorName := range names {
+		var err error
+		if mirrors[i], err = lookupSourceName(mirrorName); err != nil {
+			return nil, fmt.Errorf("Invalid source %q in %q: %w", mirrorName, name, err)
+		}
+	}
+	return NewMirroredSource(DefaultMirrorOptions, mirrors...), nil
+}
+
+// Return the cache described by `cacheFilename`, and the key of `source` in it,
+// or a `nil` cache if there is none
+func sourceCache(source *DownloadSource, cacheFilename string) (*SnapshotCache, string, error) {
+	switch cacheFilename {
+	case "":
+		return nil, "", nil
+	case defaultCacheArgument:
+		dir, err := DefaultCacheDir()
+		return newFileSnapshotCache(dir), source.CacheKey(), err
+	default:
+		return newFileSnapshotCache(""), cacheFilename, nil
+	}
+}
+
+func downloadSource(parsedSource *parseSource) (*RuleSet, error) {
+	sourceURLs, err := lookupSourceOrMirrors(parsedSource.sourceName)
+	if err != nil {
+		return nil, err
+	}
+	cache, key, err := sourceCache(sourceURLs, parsedSource.cacheFilename)
+	if err != nil {
+		return nil, err
+	}
+	var ruleSet *RuleSet
+	switch {
+	case cache == nil:
+		ruleSet, err = sourceURLs.DownloadRuleSet(true)
+	case parsedSource.snapshot != "":
+		ruleSet, err = sourceURLs.SnapshotRuleSet(cache.Snapshots(key), parsedSource.snapshot)
+	default:
+		ruleSet, err = sourceURLs.DownloadRuleSetWithCache(cache, key, parsedSource.cacheMaxAgeM, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ruleSet.SourceName = parsedSource.sourceName
+	return ruleSet, nil
 }
 
-// Get providers from a string of the format `<source>[:<cache_filename>[:<cache_max_age_minutes>]]`
+// Apply the overlay files of the source argument to `ruleSet`, if any
+func applySourceOverlays(parsedSource *parseSource, ruleSet *RuleSet) (*RuleSet, error) {
+	if len(parsedSource.overlays) == 0 {
+		return ruleSet, nil
+	}
+	overlays := make([]*Overlay, len(parsedSource.overlays))
+	for i, path := range parsedSource.overlays {
+		overlay, err := ReadOverlayFile(path)
+		if err != nil {
+			return nil, err
+		}
+		overlays[i] = overlay
+	}
+	return ruleSet.WithOverlays(overlays...)
+}
+
+// Return the snapshots kept in the cache of a source argument (see [GetProvidersFromSourceArgument]),
+// eg: `github:/var/run/clearurls_cache.json` or `github:@`
+func GetSnapshotsFromSourceArgument(source string) (*Snapshots, error) {
+	parsedSource, err := parseSourceArgument(source)
+	if err != nil {
+		return nil, err
+	}
+	sourceURLs, err := lookupSourceOrMirrors(parsedSource.sourceName)
+	if err != nil {
+		return nil, err
+	}
+	cache, key, err := sourceCache(sourceURLs, parsedSource.cacheFilename)
+	if err != nil {
+		return nil, err
+	}
+	if cache == nil {
+		return nil, fmt.Errorf("Invalid source argument %q (snapshots need a cache file)", source)
+	}
+	return cache.Snapshots(key), nil
+}
+
+// Get providers from a string of the format `<source>[:<cache_filename>[:<cache_max_age_minutes>][@<snapshot_sha256>]][?overlay=<overlay_file>[&overlay=<overlay_file>...]]`
 //
-// Where `<source>` can be one of `hardcoded`, `github` or `gitlab`
+// Where `<source>` can be one of `hardcoded`, `github`, `gitlab`, `auto` (see [SourceAuto]), or a name given
+// to [RegisterSource]. Several names separated by `|` fail over from one to the next (see [NewMirroredSource]).
+// Any other name containing a `/` or ending in `.json` is the path of a local rules file (see [NewFileSource]).
+// A `<cache_filename>` of `@` uses the default cache file of the source (see [DownloadSource.DownloadWithDefaultCache]).
+// With a cache, the last [DefaultSnapshotsKept] distinct versions are kept next to it, each a full copy of
+// the rules (see [SnapshotCache]). With `@<snapshot_sha256>` (or a prefix of it), one of them is used instead.
+// It needs a `<cache_filename>`, so that paths of local rules files may contain `@`.
+// Each `<overlay_file>` is the path of an [Overlay] applied in order to the rules before compiling them,
+// it may contain anything but `&overlay=`.
 //
 // Warning: If not `hardcoded`, the providers returned are not compiled
 //
@@ -72,6 +262,12 @@ func downloadSource(source, cache string, cacheMaxAgeM int) ([]RunnableProvider,
 //	clearurls.GetProvidersFromSourceArgument("gitlab")
 //	// Equivalent to: clearurls.SourceGitHub.Download(true)
 //
+// - Download from the first mirror that answers
+//
+//	clearurls.GetProvidersFromSourceArgument("auto")
+//	clearurls.GetProvidersFromSourceArgument("gitlab|github")
+//	// Equivalent to: clearurls.NewMirroredSource(clearurls.DefaultMirrorOptions, clearurls.SourceGitLab, clearurls.SourceGitHub).Download(true)
+//
 // - Download using a local cache file (never updating)
 //
 //	clearurls.GetProvidersFromSourceArgument("gitlab:/var/run/clearurls_cache.json")
@@ -81,14 +277,63 @@ func downloadSource(source, cache string, cacheMaxAgeM int) ([]RunnableProvider,
 //
 //	clearurls.GetProvidersFromSourceArgument("github:/var/run/clearurls_cache.json:60")
 //	// Equivalent to: clearurls.SourceGitHub.DownloadWithCache("/var/run/clearurls_cache.json", 60, true)
+//
+// - Download using the default cache file of this source (see [DefaultCacheDir]), refreshing if older than 1 hour
+//
+//	clearurls.GetProvidersFromSourceArgument("github:@:60")
+//	// Equivalent to: clearurls.SourceGitHub.DownloadWithDefaultCache(60, true)
+//
+// - Read local rules, eg: custom ones
+//
+//	clearurls.GetProvidersFromSourceArgument("./custom_rules.json")
+//	// Equivalent to: source, err := clearurls.NewFileSource("./custom_rules.json"); source.Download(true)
+//
+// - Use a previous version kept in the cache file, never downloading
+//
+//	clearurls.GetProvidersFromSourceArgument("github:/var/run/clearurls_cache.json@5bc2cef8")
+//
+// - Patch the rules with overlays, eg: to disable a provider
+//
+//	clearurls.GetProvidersFromSourceArgument("github:@:60?overlay=./our_overlay.json&overlay=./c++_sites.json")
+//	// Equivalent to: overlay, err := clearurls.ReadOverlayFile("./our_overlay.json"); ruleSet.WithOverlays(overlay, ...)
 func GetProvidersFromSourceArgument(source string) ([]RunnableProvider, error) {
 	parsedSource, err := parseSourceArgument(source)
 	if err != nil {
 		return nil, err
 	}
-	if parsedSource.sourceName == "hardcoded" {
+	if parsedSource.sourceName == hardcodedSourceName && len(parsedSource.overlays) == 0 {
 		return MustHaveHardcodedProviders()
+	}
+	ruleSet, err := ruleSetFromParsedSource(parsedSource)
+	if err != nil {
+		return nil, err
+	}
+	if parsedSource.sourceName == hardcodedSourceName {
+		return ruleSet.Providers, nil
+	}
+	return ruleSet.definitions, nil
+}
+
+// Same as [GetProvidersFromSourceArgument], but returns a compiled [RuleSet] with
+// metadata on where the rules come from, whichever the source
+func GetRuleSetFromSourceArgument(source string) (*RuleSet, error) {
+	parsedSource, err := parseSourceArgument(source)
+	if err != nil {
+		return nil, err
+	}
+	return ruleSetFromParsedSource(parsedSource)
+}
+
+func ruleSetFromParsedSource(parsedSource *parseSource) (*RuleSet, error) {
+	var ruleSet *RuleSet
+	var err error
+	if parsedSource.sourceName == hardcodedSourceName {
+		ruleSet, err = MustHaveHardcodedRuleSet()
 	} else {
-		return downloadSource(parsedSource.sourceName, parsedSource.cacheFilename, parsedSource.cacheMaxAgeM)
+		ruleSet, err = downloadSource(parsedSource)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return applySourceOverlays(parsedSource, ruleSet)
 }
@@ -0,0 +1,153 @@
+package clearurls
+
+// A `RuleSet` holds compiled providers along with where and when they were obtained
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Compiled providers, with metadata on where they come from, eg: for health checks,
+// or to find out why an URL was cleaned differently at some point.
+type RuleSet struct {
+	// Compiled providers, to use with [ClearURL]
+	Providers []RunnableProvider `json:"-"`
+	// Hex SHA-256 of the rules JSON
+	SHA256 string `json:"sha256"`
+	// With overlays applied, the SHA-256 of the rules JSON before them, and the names of
+	// the overlays, see [RuleSet.WithOverlays]
+	UpstreamSHA256 string   `json:"upstreamSHA256,omitempty"`
+	Overlays       []string `json:"overlays,omitempty"`
+	// Name of the source, as in [GetProvidersFromSourceArgument], eg: `github` or `hardcoded`.
+	// Empty if unknown.
+	SourceName string `json:"sourceName"`
+	// URL the rules JSON was downloaded from. For a source created with [NewMirroredSource],
+	// the mirror that served it.
+	SourceURL string `json:"sourceURL"`
+	// When the rules JSON was downloaded. For hardcoded rules, when they were downloaded by `go generate`.
+	FetchedAt time.Time `json:"fetchedAt"`
+	// `true` if the rules JSON was read from a [Cache] instead of downloaded
+	FromCache bool         `json:"fromCache"`
+	Stats     RuleSetStats `json:"stats"`
+
+	// Providers before compilation
+	definitions []RunnableProvider
+	// The rules JSON, if known
+	rulesJSON []byte
+}
+
+// Counts and timings of a [RuleSet]
+type RuleSetStats struct {
+	ProviderCount   int           `json:"providerCount"`
+	RegexpCount     int           `json:"regexpCount"`
+	CompileDuration time.Duration `json:"compileDuration"`
+}
+
+// Compile `definitions` into a [RuleSet], the caller completes its metadata
+func newRuleSet(definitions []RunnableProvider) (*RuleSet, error) {
+	start := time.Now()
+	compiled, err := Compile(definitions)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet := &RuleSet{
+		Providers:   compiled,
+		definitions: definitions,
+		Stats: RuleSetStats{
+			ProviderCount:   len(compiled),
+			CompileDuration: time.Since(start),
+		},
+	}
+	for _, provider := range compiled {
+		ruleSet.Stats.RegexpCount += provider.(*providerCompiled).regexpCount()
+	}
+	return ruleSet, nil
+}
+
+// Parse and compile the rules JSON of `entry` into a [RuleSet]
+func newRuleSetFromEntry(entry *CacheEntry, fromCache bool) (*RuleSet, error) {
+	definitions, err := parseJSON(entry.Data)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet, err := newRuleSet(definitions)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet.SHA256 = entry.SHA256
+	if ruleSet.SHA256 == "" {
+		ruleSet.SHA256 = sha256Hex(entry.Data)
+	}
+	ruleSet.SourceURL = entry.SourceURL
+	ruleSet.FetchedAt = entry.FetchedAt
+	ruleSet.FromCache = fromCache
+	ruleSet.rulesJSON = entry.Data
+	return ruleSet, nil
+}
+
+// Return the providers as they are in the rules JSON, sorted by name
+func (ruleSet *RuleSet) jsonDefinitions() ([]*providerJSON, error) {
+	if ruleSet.rulesJSON == nil {
+		return nil, ruleSet.errNoRulesJSON()
+	}
+	return parseJSONSorted(ruleSet.rulesJSON)
+}
+
+func (ruleSet *RuleSet) errNoRulesJSON() error {
+	if ruleSet.SourceName == hardcodedSourceName {
+		return fmt.Errorf("hardcoded rule set has no rules JSON, generate it with `cleanurls generate --embed-rules`")
+	}
+	return fmt.Errorf("rule set from %q has no rules JSON", ruleSet.SourceName)
+}
+
+// Return a copy of this rule set with its own list of providers, that callers may
+// reorder or modify freely
+func (ruleSet *RuleSet) clone() *RuleSet {
+	result := *ruleSet
+	result.Providers = slices.Clone(ruleSet.Providers)
+	result.definitions = slices.Clone(ruleSet.definitions)
+	return &result
+}
+
+// Same as [DownloadSource.Download], returning a compiled [RuleSet]
+func (source *DownloadSource) DownloadRuleSet(checkHash bool) (*RuleSet, error) {
+	entry, err := source.downloadJSON(checkHash)
+	if err != nil {
+		return nil, err
+	}
+	return source.ruleSetFromEntry(entry, false)
+}
+
+// Same as [DownloadSource.DownloadWithCacheBackend], returning a compiled [RuleSet]
+func (source *DownloadSource) DownloadRuleSetWithCache(cache Cache, key string, cacheMaxAgeM int, checkHash bool) (*RuleSet, error) {
+	entry, fromCache, err := source.cachedDownloadJSON(cache, key, cacheMaxAgeM, checkHash)
+	if err != nil {
+		return nil, err
+	}
+	return source.ruleSetFromEntry(entry, fromCache)
+}
+
+// Return the snapshot whose SHA-256 is or starts with `sum` as a compiled [RuleSet]
+func (source *DownloadSource) SnapshotRuleSet(snapshots *Snapshots, sum string) (*RuleSet, error) {
+	entry, err := snapshots.Get(sum)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateCacheEntry(entry, source.validateCachedEntry); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", sum, err)
+	}
+	return source.ruleSetFromEntry(entry, true)
+}
+
+func (source *DownloadSource) ruleSetFromEntry(entry *CacheEntry, fromCache bool) (*RuleSet, error) {
+	ruleSet, err := newRuleSetFromEntry(entry, fromCache)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet.SourceName = registeredSourceName(source)
+	if ruleSet.SourceURL == "" {
+		ruleSet.SourceURL = source.data
+	}
+	return ruleSet, nil
+}
@@ -0,0 +1,164 @@
+package clearurls
+
+// Compare the providers of two `RuleSet`s, eg: before updating hardcoded rules
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Differences between two [RuleSet]s, see [DiffRuleSets]
+type RuleSetDiff struct {
+	OldSHA256 string `json:"oldSHA256"`
+	NewSHA256 string `json:"newSHA256"`
+	// Names of providers only in the new rules
+	Added []string `json:"added"`
+	// Names of providers only in the old rules
+	Removed []string `json:"removed"`
+	// Providers in both, but with different fields
+	Modified []ProviderDiff `json:"modified"`
+}
+
+// Differences in the fields of a provider present in both rule sets
+type ProviderDiff struct {
+	Name   string      `json:"name"`
+	Fields []FieldDiff `json:"fields"`
+}
+
+// Difference in one field of a provider, named as in the ClearURLs JSON, eg: `rules`
+type FieldDiff struct {
+	Field string `json:"field"`
+	// For `urlPattern` and `completeProvider`, the old and new values
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+	// For list fields (eg: `rules`), the entries only in the new and only in the old values
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Compare the providers of `oldRules` and `newRules`, by name and field by field.
+// Both need their rules JSON, which is the case for all rule sets obtained from this
+// package, except hardcoded ones generated without it (see [GenerateGoSourceCodeForRuleSet]).
+func DiffRuleSets(oldRules, newRules *RuleSet) (*RuleSetDiff, error) {
+	oldProviders, err := oldRules.jsonDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	newProviders, err := newRules.jsonDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	oldByName := make(map[string]*providerJSON, len(oldProviders))
+	for _, provider := range oldProviders {
+		oldByName[provider.name] = provider
+	}
+	diff := &RuleSetDiff{
+		OldSHA256: oldRules.SHA256,
+		NewSHA256: newRules.SHA256,
+		Added:     []string{},
+		Removed:   []string{},
+		Modified:  []ProviderDiff{},
+	}
+	for _, newProvider := range newProviders {
+		oldProvider, ok := oldByName[newProvider.name]
+		if !ok {
+			diff.Added = append(diff.Added, newProvider.name)
+			continue
+		}
+		delete(oldByName, newProvider.name)
+		if fields := diffProviders(oldProvider, newProvider); len(fields) > 0 {
+			diff.Modified = append(diff.Modified, ProviderDiff{Name: newProvider.name, Fields: fields})
+		}
+	}
+	for name := range oldByName {
+		diff.Removed = append(diff.Removed, name)
+	}
+	slices.Sort(diff.Removed)
+	return diff, nil
+}
+
+// Return the differences between two versions of a provider, field by field
+func diffProviders(oldProvider, newProvider *providerJSON) []FieldDiff {
+	fields := []FieldDiff{}
+	if oldProvider.URLPattern != newProvider.URLPattern {
+		fields = append(fields, FieldDiff{Field: "urlPattern", Old: oldProvider.URLPattern, New: newProvider.URLPattern})
+	}
+	if oldProvider.CompleteProvider != newProvider.CompleteProvider {
+		fields = append(fields, FieldDiff{
+			Field: "completeProvider",
+			Old:   fmt.Sprint(oldProvider.CompleteProvider),
+			New:   fmt.Sprint(newProvider.CompleteProvider),
+		})
+	}
+	diffList := func(field string, oldItems, newItems []string) {
+		added := slices.DeleteFunc(slices.Clone(newItems), func(item string) bool { return slices.Contains(oldItems, item) })
+		removed := slices.DeleteFunc(slices.Clone(oldItems), func(item string) bool { return slices.Contains(newItems, item) })
+		if len(added) > 0 || len(removed) > 0 {
+			fields = append(fields, FieldDiff{Field: field, Added: added, Removed: removed})
+		}
+	}
+	diffList("rules", oldProvider.Rules, newProvider.Rules)
+	diffList("rawRules", oldProvider.RawRules, newProvider.RawRules)
+	diffList("referralMarketing", oldProvider.ReferralMarketing, newProvider.ReferralMarketing)
+	diffList("exceptions", oldProvider.Exceptions, newProvider.Exceptions)
+	diffList("redirections", oldProvider.Redirections, newProvider.Redirections)
+	return fields
+}
+
+// `true` if both rule sets have the same providers
+func (diff *RuleSetDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Modified) == 0
+}
+
+// Return the differences of the provider named `name`, or `nil` if it is unchanged.
+// An added or removed provider has no fields.
+func (diff *RuleSetDiff) Provider(name string) *ProviderDiff {
+	if slices.Contains(diff.Added, name) || slices.Contains(diff.Removed, name) {
+		return &ProviderDiff{Name: name}
+	}
+	for i := range diff.Modified {
+		if diff.Modified[i].Name == name {
+			return &diff.Modified[i]
+		}
+	}
+	return nil
+}
+
+// Human readable version of the differences
+func (diff *RuleSetDiff) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "--- %s\n+++ %s\n", diff.OldSHA256, diff.NewSHA256)
+	for _, name := range diff.Added {
+		fmt.Fprintf(&builder, "+ %s\n", name)
+	}
+	for _, name := range diff.Removed {
+		fmt.Fprintf(&builder, "- %s\n", name)
+	}
+	for _, provider := range diff.Modified {
+		builder.WriteString(provider.String())
+	}
+	if diff.IsEmpty() {
+		builder.WriteString("  (no differences)\n")
+	}
+	return builder.String()
+}
+
+// Human readable version of the differences of one provider
+func (provider *ProviderDiff) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "~ %s\n", provider.Name)
+	for _, field := range provider.Fields {
+		if field.Old != "" || field.New != "" {
+			fmt.Fprintf(&builder, "    %s: %q -> %q\n", field.Field, field.Old, field.New)
+			continue
+		}
+		for _, item := range field.Added {
+			fmt.Fprintf(&builder, "    %s: + %q\n", field.Field, item)
+		}
+		for _, item := range field.Removed {
+			fmt.Fprintf(&builder, "    %s: - %q\n", field.Field, item)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,140 @@
+package clearurls
+
+// Compare how two `RuleSet`s clean a sample of real URLs, eg: before updating rules
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// URLs cleaned differently by two [RuleSet]s, see [MeasureImpact]
+type ImpactReport struct {
+	OldSHA256 string `json:"oldSHA256"`
+	NewSHA256 string `json:"newSHA256"`
+	// Number of URLs cleaned with both rule sets
+	URLCount int `json:"urlCount"`
+	// Number of those with a different result
+	ChangedCount int `json:"changedCount"`
+	// Changed URLs grouped by responsible provider, most changes first
+	Providers []ProviderImpact `json:"providers"`
+}
+
+// URLs whose result changed because of one provider
+type ProviderImpact struct {
+	// Name of the first provider that acted differently, empty if none did (eg: on errors)
+	Provider string      `json:"provider"`
+	Count    int         `json:"count"`
+	URLs     []URLImpact `json:"urls"`
+}
+
+// Results of both rule sets for one URL
+type URLImpact struct {
+	URL      string `json:"url"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+	OldError string `json:"oldError,omitempty"`
+	NewError string `json:"newError,omitempty"`
+}
+
+// Read one URL per line from `reader`, skipping empty lines and lines starting with `#`
+func ReadURLCorpus(reader io.Reader) ([]string, error) {
+	urls := []string{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// Clean each of `urls` with the providers of both `oldRules` and `newRules`, and report
+// those with a different result (or error), grouped by the provider responsible: the
+// first one to act differently between both runs.
+func MeasureImpact(oldRules, newRules *RuleSet, urls []string, keepMarketingReferrals bool) *ImpactReport {
+	report := &ImpactReport{
+		OldSHA256: oldRules.SHA256,
+		NewSHA256: newRules.SHA256,
+		URLCount:  len(urls),
+		Providers: []ProviderImpact{},
+	}
+	byProvider := map[string]*ProviderImpact{}
+	for _, url := range urls {
+		oldTrace, oldErr := ClearURLWithTrace(oldRules.Providers, url, keepMarketingReferrals)
+		newTrace, newErr := ClearURLWithTrace(newRules.Providers, url, keepMarketingReferrals)
+		impact := URLImpact{URL: url, Old: oldTrace.Output, New: newTrace.Output}
+		if oldErr != nil {
+			impact.OldError = oldErr.Error()
+		}
+		if newErr != nil {
+			impact.NewError = newErr.Error()
+		}
+		if impact.Old == impact.New && impact.OldError == impact.NewError {
+			continue
+		}
+		report.ChangedCount++
+		name := responsibleProvider(oldTrace, newTrace)
+		providerImpact, ok := byProvider[name]
+		if !ok {
+			providerImpact = &ProviderImpact{Provider: name, URLs: []URLImpact{}}
+			byProvider[name] = providerImpact
+		}
+		providerImpact.Count++
+		providerImpact.URLs = append(providerImpact.URLs, impact)
+	}
+	for _, providerImpact := range byProvider {
+		report.Providers = append(report.Providers, *providerImpact)
+	}
+	slices.SortFunc(report.Providers, func(a, b ProviderImpact) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Provider, b.Provider))
+	})
+	return report
+}
+
+// Name of the provider of the first step that differs between both traces, or
+// an empty string if none does
+func responsibleProvider(oldTrace, newTrace *CleanTrace) string {
+	for i := 0; i < max(len(oldTrace.Steps), len(newTrace.Steps)); i++ {
+		if i >= len(newTrace.Steps) {
+			return oldTrace.Steps[i].Provider
+		}
+		if i >= len(oldTrace.Steps) || oldTrace.Steps[i].Provider != newTrace.Steps[i].Provider || oldTrace.Steps[i].After != newTrace.Steps[i].After {
+			return newTrace.Steps[i].Provider
+		}
+	}
+	return ""
+}
+
+// Human readable version of the report
+func (report *ImpactReport) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "--- %s\n+++ %s\n", report.OldSHA256, report.NewSHA256)
+	fmt.Fprintf(&builder, "%d of %d URLs cleaned differently\n", report.ChangedCount, report.URLCount)
+	for _, providerImpact := range report.Providers {
+		name := providerImpact.Provider
+		if name == "" {
+			name = "(no provider)"
+		}
+		fmt.Fprintf(&builder, "\n%s: %d\n", name, providerImpact.Count)
+		for _, impact := range providerImpact.URLs {
+			fmt.Fprintf(&builder, "  %s\n", impact.URL)
+			fmt.Fprintf(&builder, "    - %s\n", impactResult(impact.Old, impact.OldError))
+			fmt.Fprintf(&builder, "    + %s\n", impactResult(impact.New, impact.NewError))
+		}
+	}
+	return builder.String()
+}
+
+func impactResult(result, err string) string {
+	if err != "" {
+		return "error: " + err
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package clearurls
+
+// Run the examples providers may carry in their `tests` field, to catch rule edits
+// that break them
+
+import "fmt"
+
+// One example in the optional `tests` field of a provider in the ClearURLs JSON:
+// `{"input": "...", "expected": "..."}` or `{"input": "...", "blocked": true}`
+type ProviderTest struct {
+	Input string `json:"input"`
+	// What [ClearURL] returns for `Input`, with all providers of the rules
+	Expected string `json:"expected,omitempty"`
+	// `true` if `Input` matches a `completeProvider`, instead of checking `Expected`
+	Blocked bool `json:"blocked,omitempty"`
+}
+
+// A [ProviderTest] that did not give the expected result
+type ProviderTestFailure struct {
+	Provider string       `json:"provider"`
+	Test     ProviderTest `json:"test"`
+	// What [ClearURL] returned
+	Got string `json:"got"`
+	// Whether a `completeProvider` matched
+	GotBlocked bool `json:"gotBlocked,omitempty"`
+	// Error returned by [ClearURL], if any
+	Err string `json:"error,omitempty"`
+}
+
+func (failure *ProviderTestFailure) String() string {
+	return fmt.Sprintf("provider %q: %s", failure.Provider, failure.describe())
+}
+
+// Same as `String`, without the provider
+func (failure *ProviderTestFailure) describe() string {
+	switch {
+	case failure.Err != "":
+		return fmt.Sprintf("test %q: %s", truncateForError(failure.Test.Input), failure.Err)
+	case failure.Test.Blocked:
+		return fmt.Sprintf("test %q: expected blocked, got %q", truncateForError(failure.Test.Input), truncateForError(failure.Got))
+	default:
+		return fmt.Sprintf("test %q: expected %q, got %q", truncateForError(failure.Test.Input), truncateForError(failure.Test.Expected), truncateForError(failure.Got))
+	}
+}
+
+// Run the `tests` of each provider of `definitions` through [ClearURL] with `compiled`,
+// returning those that fail, by provider name
+func runProviderTests(definitions []*providerJSON, compiled []RunnableProvider) []ProviderTestFailure {
+	failures := []ProviderTestFailure{}
+	for _, provider := range definitions {
+		for _, test := range provider.Tests {
+			result := checkURLExpectation(compiled, test.Input, test.Expected, test.Blocked, false)
+			if !result.Passed {
+				failures = append(failures, ProviderTestFailure{
+					Provider:   provider.name,
+					Test:       test,
+					Got:        result.Got,
+					GotBlocked: result.GotBlocked,
+					Err:        result.Err,
+				})
+			}
+		}
+	}
+	return failures
+}
+
+// Run the examples in the `tests` field of the providers of this rule set, returning
+// those that fail. Expected results are those of [ClearURL] with all providers, and
+// without keeping referral marketing.
+func (ruleSet *RuleSet) RunProviderTests() ([]ProviderTestFailure, error) {
+	definitions, err := ruleSet.jsonDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	return runProviderTests(definitions, ruleSet.Providers), nil
+}
+
+// Return a copy of this source that only accepts rules whose providers pass the
+// examples in their `tests` field (see [RuleSet.RunProviderTests]), whether downloaded
+// or read from a cache. Fails with a [ProviderTestsError] otherwise.
+func (source *DownloadSource) WithProviderTests() *DownloadSource {
+	result := source.clone()
+	result.checkProviderTests = true
+	return result
+}
+
+// Check the rules JSON `data` passes its own examples, if requested
+func (source *DownloadSource) checkProviderTestsOf(data []byte) error {
+	if !source.checkProviderTests {
+		return nil
+	}
+	// In the order of the providers returned to callers, which matters for redirections
+	providers, err := parseJSON(data)
+	if err != nil {
+		return err
+	}
+	compiled, err := Compile(providers)
+	if err != nil {
+		return err
+	}
+	definitions := make([]*providerJSON, len(providers))
+	for i, provider := range providers {
+		definitions[i] = provider.(*providerJSON)
+	}
+	if failures := runProviderTests(definitions, compiled); len(failures) > 0 {
+		return &ProviderTestsError{DataURL: source.data, Failures: failures}
+	}
+	return nil
+}
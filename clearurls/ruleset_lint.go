@@ -0,0 +1,329 @@
+package clearurls
+
+// Check rules for mistakes before using them, eg: custom rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// How bad a [LintIssue] is
+type LintSeverity string
+
+const (
+	// The rules can't be used as they are
+	LintError LintSeverity = "error"
+	// The rules can be used, but probably don't do what was intended
+	LintWarning LintSeverity = "warning"
+)
+
+// One problem found by [LintRules]
+type LintIssue struct {
+	Severity LintSeverity `json:"severity"`
+	Provider string       `json:"provider"`
+	// Name of the field in the ClearURLs JSON, eg: `urlPattern`, empty for the whole provider
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (issue LintIssue) String() string {
+	location := issue.Provider
+	if issue.Field != "" {
+		location += "." + issue.Field
+	}
+	return fmt.Sprintf("%-7s %s: %s", issue.Severity, location, issue.Message)
+}
+
+// Problems found in rules by [LintRules], by provider name
+type LintReport struct {
+	ProviderCount int         `json:"providerCount"`
+	ErrorCount    int         `json:"errorCount"`
+	WarningCount  int         `json:"warningCount"`
+	Issues        []LintIssue `json:"issues"`
+}
+
+func (report *LintReport) add(severity LintSeverity, provider, field, format string, args ...any) {
+	report.Issues = append(report.Issues, LintIssue{
+		Severity: severity,
+		Provider: provider,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == LintError {
+		report.ErrorCount++
+	} else {
+		report.WarningCount++
+	}
+}
+
+// Human readable version of the report
+func (report *LintReport) String() string {
+	var builder strings.Builder
+	for _, issue := range report.Issues {
+		builder.WriteString(issue.String() + "\n")
+	}
+	fmt.Fprintf(&builder, "%d providers: %d errors, %d warnings\n", report.ProviderCount, report.ErrorCount, report.WarningCount)
+	return builder.String()
+}
+
+// URLs that no `urlPattern` meant for some sites should all match
+var lintUnrelatedURLs = []string{
+	"https://example.com/",
+	"http://localhost:8080/index.html?query=1#fragment",
+	"https://www.clearurls-lint.invalid/some/path",
+}
+
+// Check the ClearURLs JSON `rulesJSON` for:
+//
+//   - Errors: patterns that fail to compile, providers defined more than once, and
+//     failing examples in `tests` (see [RuleSet.RunProviderTests])
+//   - Warnings: duplicate entries in a field, `urlPattern`s not anchored or matching
+//     every URL, `completeProvider` providers with other fields, `exceptions` that are
+//     empty or don't seem to ever match the `urlPattern`, and `redirections` without a
+//     capture group
+//
+// Whether an exception matches the `urlPattern` is guessed from one example URL (see
+// [exampleMatch]), so it is only ever a warning. Tests are skipped if patterns fail
+// to compile.
+//
+// Fails only if `rulesJSON` can't be parsed at all.
+func LintRules(rulesJSON []byte) (*LintReport, error) {
+	duplicates, err := duplicateProviderNames(rulesJSON)
+	if err != nil {
+		return nil, err
+	}
+	// Tests run in the order of the document, as the providers are applied, issues are reported by name
+	providers, err := parseJSONDefinitions(rulesJSON)
+	if err != nil {
+		return nil, err
+	}
+	report := &LintReport{ProviderCount: len(providers), Issues: []LintIssue{}}
+	for _, name := range duplicates {
+		report.add(LintError, name, "", "provider defined more than once, only the last definition is used")
+	}
+	duplicateErrors := report.ErrorCount
+	sorted := slices.Clone(providers)
+	sortDefinitionsByName(sorted)
+	for _, provider := range sorted {
+		lintProvider(report, provider)
+	}
+	if report.ErrorCount > duplicateErrors {
+		return report, nil // Patterns that fail to compile are the only other errors, tests need them to compile
+	}
+	definitions := make([]RunnableProvider, len(providers))
+	for i, provider := range providers {
+		definitions[i] = provider
+	}
+	compiled, err := Compile(definitions)
+	if err != nil {
+		return nil, err
+	}
+	for _, failure := range runProviderTests(sorted, compiled) {
+		report.add(LintError, failure.Provider, "tests", "%s", failure.describe())
+	}
+	return report, nil
+}
+
+// Same as [LintRules] with the rules JSON of this rule set
+func (ruleSet *RuleSet) Lint() (*LintReport, error) {
+	if ruleSet.rulesJSON == nil {
+		return nil, ruleSet.errNoRulesJSON()
+	}
+	return LintRules(ruleSet.rulesJSON)
+}
+
+// Same as [LintRules] with the rules of a source argument (see [GetProvidersFromSourceArgument]).
+// Local rules files are linted even if they don't compile, other sources are rejected
+// when they don't.
+func LintSourceArgument(source string) (*LintReport, error) {
+	parsedSource, err := parseSourceArgument(source)
+	if err != nil {
+		return nil, err
+	}
+	if parsedSource.cacheFilename == "" && len(parsedSource.overlays) == 0 && isFileSourceName(parsedSource.sourceName) {
+		fileSource, err := NewFileSource(parsedSource.sourceName)
+		if err != nil {
+			return nil, err
+		}
+		rulesJSON, _, err := getHTTPBody(fileSource.data, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		return LintRules(rulesJSON)
+	}
+	ruleSet, err := GetRuleSetFromSourceArgument(source)
+	if err != nil {
+		return nil, err
+	}
+	return ruleSet.Lint()
+}
+
+// Return the names of providers that appear more than once in the rules JSON,
+// which `encoding/json` silently merges
+func duplicateProviderNames(rulesJSON []byte) ([]string, error) {
+	seen := map[string]bool{}
+	duplicates := []string{}
+	err := forEachRawProvider(rulesJSON, func(name string, _ json.RawMessage) error {
+		if seen[name] && !slices.Contains(duplicates, name) {
+			duplicates = append(duplicates, name)
+		}
+		seen[name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(duplicates)
+	return duplicates, nil
+}
+
+// Compile `pattern` as the runner would, with `prefix` and `suffix` around it, reporting errors
+func lintCompile(report *LintReport, provider, field, pattern, prefix, suffix string) *regexp.Regexp {
+	rx, err := regexp.Compile(caseInsensitiveRXStrPrefix + prefix + pattern + suffix)
+	if err != nil {
+		report.add(LintError, provider, field, "invalid pattern %q: %s", truncateForError(pattern), truncateForError(err.Error()))
+		return nil
+	}
+	return rx
+}
+
+func lintProvider(report *LintReport, provider *providerJSON) {
+	name := provider.name
+	listFields := []struct {
+		field    string
+		patterns []string
+	}{
+		{"rules", provider.Rules},
+		{"rawRules", provider.RawRules},
+		{"referralMarketing", provider.ReferralMarketing},
+		{"exceptions", provider.Exceptions},
+		{"redirections", provider.Redirections},
+	}
+	for _, list := range listFields {
+		seen := map[string]bool{}
+		for _, pattern := range list.patterns {
+			if key := strings.ToLower(pattern); seen[key] {
+				report.add(LintWarning, name, list.field, "duplicate entry %q", truncateForError(pattern))
+			} else {
+				seen[key] = true
+			}
+		}
+	}
+
+	urlPattern := lintCompile(report, name, "urlPattern", provider.URLPattern, "", "")
+	for _, rule := range provider.Rules {
+		lintCompile(report, name, "rules", rule, "^", "$")
+	}
+	for _, rule := range provider.RawRules {
+		lintCompile(report, name, "rawRules", rule, "", "")
+	}
+	for _, rule := range provider.ReferralMarketing {
+		lintCompile(report, name, "referralMarketing", rule, "", "")
+	}
+	if urlPattern != nil {
+		if slices.IndexFunc(lintUnrelatedURLs, func(url string) bool { return !urlPattern.MatchString(url) }) < 0 {
+			report.add(LintWarning, name, "urlPattern", "pattern %q matches every URL", truncateForError(provider.URLPattern))
+		} else if !strings.HasPrefix(provider.URLPattern, "^") {
+			report.add(LintWarning, name, "urlPattern", "pattern %q is not anchored with `^`, it can match anywhere in the URL", truncateForError(provider.URLPattern))
+		}
+	}
+	for _, exception := range provider.Exceptions {
+		rx := lintCompile(report, name, "exceptions", exception, "", "")
+		if rx == nil || urlPattern == nil {
+			continue
+		}
+		if exception == "" {
+			report.add(LintWarning, name, "exceptions", "empty exception matches every URL, the provider never applies")
+			continue
+		}
+		if example, ok := exampleMatch(exception); ok && rx.MatchString(example) && !urlPattern.MatchString(example) {
+			report.add(LintWarning, name, "exceptions", "exception %q seems to never match urlPattern, eg: %q", truncateForError(exception), truncateForError(example))
+		}
+	}
+	for _, redirection := range provider.Redirections {
+		if rx := lintCompile(report, name, "redirections", redirection, "", ""); rx != nil && rx.NumSubexp() == 0 {
+			report.add(LintWarning, name, "redirections", "redirection %q has no capture group for the target URL", truncateForError(redirection))
+		}
+	}
+
+	if provider.CompleteProvider {
+		others := []string{}
+		for _, list := range listFields {
+			if len(list.patterns) > 0 {
+				others = append(others, list.field)
+			}
+		}
+		if len(others) > 0 {
+			report.add(LintWarning, name, "completeProvider", "completeProvider also has %s, inconsistent with the documentation", strings.Join(others, ", "))
+		}
+	}
+}
+
+// Build a string matched by `pattern`, taking the simplest path through it, or
+// return `false` if that's not practical
+func exampleMatch(pattern string) (string, bool) {
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	var builder strings.Builder
+	var write func(node *syntax.Regexp) bool
+	write = func(node *syntax.Regexp) bool {
+		switch node.Op {
+		case syntax.OpLiteral:
+			builder.WriteString(string(node.Rune))
+		case syntax.OpCharClass:
+			if len(node.Rune) == 0 {
+				return false
+			}
+			builder.WriteRune(charClassExample(node.Rune))
+		case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+			builder.WriteRune('a')
+		case syntax.OpCapture:
+			return write(node.Sub[0])
+		case syntax.OpPlus:
+			return write(node.Sub[0])
+		case syntax.OpRepeat:
+			for range node.Min {
+				if !write(node.Sub[0]) {
+					return false
+				}
+			}
+		case syntax.OpConcat:
+			for _, sub := range node.Sub {
+				if !write(sub) {
+					return false
+				}
+			}
+		case syntax.OpAlternate:
+			return write(node.Sub[0])
+		case syntax.OpStar, syntax.OpQuest, syntax.OpEmptyMatch,
+			syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+			syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+			// Nothing to write
+		default:
+			return false
+		}
+		return true
+	}
+	if !write(parsed) {
+		return "", false
+	}
+	return builder.String(), true
+}
+
+// Pick a readable character in the ranges of a character class, eg: `a` for `[^/]`
+func charClassExample(ranges []rune) rune {
+	for _, preferred := range "a0-/" {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= preferred && preferred <= ranges[i+1] {
+				return preferred
+			}
+		}
+	}
+	return ranges[0]
+}
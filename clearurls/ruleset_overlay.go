@@ -0,0 +1,270 @@
+package clearurls
+
+// Patch providers of the ClearURLs data with small overlay files, instead of
+// maintaining a fork of the whole rules JSON
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// One change to a provider, see [Overlay]
+type OverlayOperation struct {
+	// One of:
+	//  - `disable`: remove the provider
+	//  - `add`: append `Value` to the `Field` list, eg: `rules` or `exceptions`
+	//  - `remove`: remove `Value` from the `Field` list, failing if it isn't there
+	//  - `set`: set `Field` to `Value`, for `urlPattern` or `completeProvider`
+	Op       string `json:"op"`
+	Provider string `json:"provider"`
+	Field    string `json:"field,omitempty"`
+	// A string, or a boolean for `completeProvider`
+	Value any `json:"value,omitempty"`
+}
+
+// Changes applied in order to the providers of rules JSON before they are compiled.
+// Applied with [RuleSet.WithOverlays], source arguments (see [GetProvidersFromSourceArgument]),
+// or the `--overlay <file>` flag of `cleanurls`. Example:
+//
+//	{
+//	  "operations": [
+//	    {"op": "disable", "provider": "doubleclick"},
+//	    {"op": "remove", "provider": "amazon", "field": "rules", "value": "keywords"},
+//	    {"op": "add", "provider": "google", "field": "exceptions", "value": "^https?:\\/\\/mail\\.google\\.com"},
+//	    {"op": "add", "provider": "globalRules", "field": "rules", "value": "mc_eid"},
+//	    {"op": "set", "provider": "youtube", "field": "completeProvider", "value": false}
+//	  ]
+//	}
+//
+// Operations fail if their provider or value is missing, so that changes to the
+// upstream rules making an overlay obsolete are noticed.
+type Overlay struct {
+	// Where the overlay was read from, for errors and [RuleSet.Overlays]
+	Name       string             `json:"-"`
+	Operations []OverlayOperation `json:"operations"`
+}
+
+// Read an [Overlay] from JSON, named `name`
+func ReadOverlay(reader io.Reader, name string) (*Overlay, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	overlay := &Overlay{Name: name}
+	if err := decoder.Decode(overlay); err != nil {
+		return nil, fmt.Errorf("overlay %q: %w: %w", name, ErrInvalidJSON, err)
+	}
+	return overlay, nil
+}
+
+// Read an [Overlay] from a local file, named after its path
+func ReadOverlayFile(path string) (*Overlay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadOverlay(file, path)
+}
+
+// A provider as it is in the rules JSON, patched by overlays without losing the
+// fields this package doesn't parse, eg: `forceRedirection`
+type rawProvider struct {
+	name string
+	raw  json.RawMessage
+	// Decoded from `raw` on the first change, `nil` until then
+	fields map[string]json.RawMessage
+}
+
+// Return the providers of `rulesJSON` in the order of the document. Only the last
+// definition of a provider defined more than once is kept, as with [parseJSON].
+func parseRawProviders(rulesJSON []byte) ([]*rawProvider, error) {
+	providers := []*rawProvider{}
+	byName := map[string]*rawProvider{}
+	err := forEachRawProvider(rulesJSON, func(name string, raw json.RawMessage) error {
+		if provider := byName[name]; provider != nil {
+			provider.raw = raw
+		} else {
+			byName[name] = &rawProvider{name: name, raw: raw}
+			providers = append(providers, byName[name])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
+// Encode `providers` as a rules JSON document, in order, untouched ones as they were
+func marshalRawProviders(providers []*rawProvider) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(`{"providers":{`)
+	for i, provider := range providers {
+		name, err := json.Marshal(provider.name)
+		if err != nil {
+			return nil, err
+		}
+		raw := provider.raw
+		if provider.fields != nil {
+			if raw, err = json.Marshal(provider.fields); err != nil {
+				return nil, err
+			}
+		}
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(raw)
+	}
+	buffer.WriteString("}}")
+	return buffer.Bytes(), nil
+}
+
+// Return the key of `field` in the provider, matched case-insensitively as `encoding/json` does
+func (provider *rawProvider) fieldKey(field string) (string, error) {
+	if provider.fields == nil {
+		if err := json.Unmarshal(provider.raw, &provider.fields); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+		}
+	}
+	for key := range provider.fields {
+		if strings.EqualFold(key, field) {
+			return key, nil
+		}
+	}
+	return field, nil
+}
+
+// Decode `field` into `value`, left as is if the field is absent
+func (provider *rawProvider) getField(field string, value any) error {
+	key, err := provider.fieldKey(field)
+	if err != nil {
+		return err
+	}
+	raw, found := provider.fields[key]
+	if !found {
+		return nil
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidJSON, field, err)
+	}
+	return nil
+}
+
+func (provider *rawProvider) setField(field string, value any) error {
+	key, err := provider.fieldKey(field)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	provider.fields[key] = raw
+	return nil
+}
+
+// Fields of providers that are lists of patterns, see [OverlayOperation]
+var overlayListFields = []string{"rules", "rawRules", "referralMarketing", "exceptions", "redirections"}
+
+func (operation *OverlayOperation) apply(providers []*rawProvider) ([]*rawProvider, error) {
+	index := slices.IndexFunc(providers, func(provider *rawProvider) bool { return provider.name == operation.Provider })
+	if index < 0 {
+		return nil, fmt.Errorf("no provider %q", operation.Provider)
+	}
+	provider := providers[index]
+	if operation.Op == "disable" {
+		return slices.Delete(providers, index, index+1), nil
+	}
+	stringValue, isString := operation.Value.(string)
+	_, isBool := operation.Value.(bool)
+	switch {
+	case operation.Op == "add" || operation.Op == "remove":
+		if !slices.Contains(overlayListFields, operation.Field) {
+			return nil, fmt.Errorf("unknown list field %q", operation.Field)
+		}
+		if !isString {
+			return nil, fmt.Errorf("value of %s must be a string", operation.Op)
+		}
+		list := []string{}
+		if err := provider.getField(operation.Field, &list); err != nil {
+			return nil, err
+		}
+		found := slices.Index(list, stringValue)
+		switch {
+		case operation.Op == "remove" && found < 0:
+			return nil, fmt.Errorf("no %q in %s", stringValue, operation.Field)
+		case operation.Op == "remove":
+			list = slices.Delete(list, found, found+1)
+		case found < 0:
+			list = append(list, stringValue)
+		default:
+			return providers, nil // Already there
+		}
+		if err := provider.setField(operation.Field, list); err != nil {
+			return nil, err
+		}
+	case operation.Op == "set" && (operation.Field == "urlPattern" && isString || operation.Field == "completeProvider" && isBool):
+		if err := provider.setField(operation.Field, operation.Value); err != nil {
+			return nil, err
+		}
+	case operation.Op == "set":
+		return nil, fmt.Errorf("can't set %q to %#v", operation.Field, operation.Value)
+	default:
+		return nil, fmt.Errorf("unknown operation %q", operation.Op)
+	}
+	return providers, nil
+}
+
+// Apply the operations of the overlay to `providers`, which are modified
+func (overlay *Overlay) apply(providers []*rawProvider) ([]*rawProvider, error) {
+	for i, operation := range overlay.Operations {
+		var err error
+		if providers, err = operation.apply(providers); err != nil {
+			return nil, fmt.Errorf("overlay %q, operation %d (%s %s): %w", overlay.Name, i+1, operation.Op, operation.Provider, err)
+		}
+	}
+	return providers, nil
+}
+
+// Return a new rule set with `overlays` applied in order to the rules JSON of this one,
+// which it needs, as for all rule sets obtained from this package. Its `SHA256` is
+// that of the resulting rules JSON, where providers keep their order, and their fields
+// not touched by the overlays are left as they were.
+func (ruleSet *RuleSet) WithOverlays(overlays ...*Overlay) (*RuleSet, error) {
+	if ruleSet.rulesJSON == nil {
+		return nil, ruleSet.errNoRulesJSON()
+	}
+	providers, err := parseRawProviders(ruleSet.rulesJSON)
+	if err != nil {
+		return nil, err
+	}
+	for _, overlay := range overlays {
+		if providers, err = overlay.apply(providers); err != nil {
+			return nil, err
+		}
+	}
+	rulesJSON, err := marshalRawProviders(providers)
+	if err != nil {
+		return nil, err
+	}
+	result, err := newRuleSetFromEntry(&CacheEntry{Data: rulesJSON, SourceURL: ruleSet.SourceURL, FetchedAt: ruleSet.FetchedAt}, ruleSet.FromCache)
+	if err != nil {
+		return nil, err
+	}
+	result.SourceName = ruleSet.SourceName
+	result.UpstreamSHA256 = ruleSet.SHA256
+	if ruleSet.UpstreamSHA256 != "" {
+		result.UpstreamSHA256 = ruleSet.UpstreamSHA256
+	}
+	result.Overlays = slices.Clone(ruleSet.Overlays)
+	for _, overlay := range overlays {
+		result.Overlays = append(result.Overlays, overlay.Name)
+	}
+	return result, nil
+}
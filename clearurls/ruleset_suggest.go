@@ -0,0 +1,197 @@
+package clearurls
+
+// Find query and fragment keys the rules leave in a corpus of URLs that look like
+// tracking parameters, and suggest providers removing them
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Thresholds of [SuggestRules]
+type SuggestOptions struct {
+	// Keys seen fewer times on a host are ignored, as uniqueness can't be judged
+	MinOccurrences int
+	// Keys scoring less are not suggested, between 0 and 1
+	MinScore float64
+	// Number of example values kept for each key, none if negative
+	MaxExamples int
+}
+
+// Options used by `cleanurls suggest`
+var DefaultSuggestOptions = SuggestOptions{
+	MinOccurrences: 2,
+	MinScore:       0.5,
+	MaxExamples:    3,
+}
+
+// Key names commonly used by trackers, eg: `utm_source`, `gclid` or `mc_eid`
+var trackerKeyPattern = regexp.MustCompile(`(?i)^(?:utm_|mc_|hsa_|_hs|ga_|_ga|pk_|mtm_|oly_|vero_|mkt_|aff_|trk|track|click|campaign|affiliate|[a-z]{1,4}clid$|igshid|ref_?src|cmpid|cid$|sid$|si$)`)
+
+// A key left by the rules on a host, that looks like a tracking parameter
+type KeySuggestion struct {
+	// Lowercase host, without `www.`
+	Host string `json:"host"`
+	Key  string `json:"key"`
+	// Between 0 and 1, higher is more likely to be a tracking parameter
+	Score float64 `json:"score"`
+	// Number of times the key was seen on the host, and how many distinct values it had
+	Occurrences    int `json:"occurrences"`
+	DistinctValues int `json:"distinctValues"`
+	// Mean Shannon entropy of the values, in bits
+	MeanEntropy float64 `json:"meanEntropy"`
+	// `true` if the key is named like a common tracking parameter
+	TrackerName bool     `json:"trackerName"`
+	Examples    []string `json:"examples"`
+}
+
+// Outcome of [SuggestRules]
+type Suggestions struct {
+	URLCount int `json:"urlCount"`
+	// Highest scores first
+	Keys []KeySuggestion `json:"keys"`
+}
+
+// Values seen for a key on a host
+type keyObservation struct {
+	distinct    map[string]struct{}
+	examples    []string // The first distinct values, up to `SuggestOptions.MaxExamples`
+	entropySum  float64
+	occurrences int
+}
+
+// Clean each of `urls` with `providers`, then group the query and fragment keys left
+// by host and score them: high entropy values, values unique to each URL, and names
+// of known trackers all make a key more likely to be a tracking parameter.
+// URLs blocked by a `completeProvider` are skipped.
+//
+// Suggestions are for a human to review, see [Suggestions.RulesJSON].
+func SuggestRules(providers []RunnableProvider, urls []string, options SuggestOptions) *Suggestions {
+	observations := map[[2]string]*keyObservation{} // By host and key
+	for _, rawURL := range urls {
+		trace, err := ClearURLWithTrace(providers, rawURL, false)
+		if err != nil || slices.ContainsFunc(trace.Steps, func(step CleanStep) bool { return step.Complete }) {
+			continue
+		}
+		parsedURL, err := url.Parse(trace.Output)
+		if err != nil {
+			continue
+		}
+		host := strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")
+		values := parsedURL.Query()
+		if fragmentValues, err := url.ParseQuery(parsedURL.Fragment); err == nil {
+			for key, keyValues := range fragmentValues {
+				values[key] = append(values[key], keyValues...)
+			}
+		}
+		for key, keyValues := range values {
+			observation := observations[[2]string{host, key}]
+			if observation == nil {
+				observation = &keyObservation{distinct: map[string]struct{}{}, examples: []string{}}
+				observations[[2]string{host, key}] = observation
+			}
+			for _, value := range keyValues {
+				observation.occurrences++
+				observation.entropySum += shannonEntropyBits(value)
+				if _, seen := observation.distinct[value]; !seen {
+					observation.distinct[value] = struct{}{}
+					if len(observation.examples) < options.MaxExamples {
+						observation.examples = append(observation.examples, value)
+					}
+				}
+			}
+		}
+	}
+	suggestions := &Suggestions{URLCount: len(urls), Keys: []KeySuggestion{}}
+	for hostAndKey, observation := range observations {
+		if observation.occurrences < options.MinOccurrences {
+			continue
+		}
+		suggestion := KeySuggestion{
+			Host:           hostAndKey[0],
+			Key:            hostAndKey[1],
+			Occurrences:    observation.occurrences,
+			DistinctValues: len(observation.distinct),
+			MeanEntropy:    observation.entropySum / float64(observation.occurrences),
+			TrackerName:    trackerKeyPattern.MatchString(hostAndKey[1]),
+			Examples:       observation.examples,
+		}
+		// An ID of 16 random hex digits has 64 bits, more than most parameters that matter
+		entropyScore := math.Min(1, suggestion.MeanEntropy/64)
+		uniquenessScore := float64(suggestion.DistinctValues) / float64(suggestion.Occurrences)
+		nameScore := 0.0
+		if suggestion.TrackerName {
+			nameScore = 1
+		}
+		suggestion.Score = math.Round((0.4*entropyScore+0.3*uniquenessScore+0.3*nameScore)*100) / 100
+		if suggestion.Score >= options.MinScore {
+			suggestions.Keys = append(suggestions.Keys, suggestion)
+		}
+	}
+	slices.SortFunc(suggestions.Keys, func(a, b KeySuggestion) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Host, b.Host), strings.Compare(a.Key, b.Key))
+	})
+	return suggestions
+}
+
+// Total Shannon entropy of the characters of `value`, in bits
+func shannonEntropyBits(value string) float64 {
+	if value == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	length := 0
+	for _, char := range value {
+		counts[char]++
+		length++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		probability := float64(count) / float64(length)
+		entropy -= probability * math.Log2(probability)
+	}
+	return entropy * float64(length)
+}
+
+// Return a ClearURLs rules JSON document with a provider for each host, named
+// `suggested_<host>`, removing the suggested keys
+func (suggestions *Suggestions) RulesJSON() ([]byte, error) {
+	byHost := map[string]*providerJSON{}
+	providers := []*providerJSON{}
+	for _, suggestion := range suggestions.Keys {
+		provider := byHost[suggestion.Host]
+		if provider == nil {
+			provider = &providerJSON{
+				name:       "suggested_" + suggestion.Host,
+				URLPattern: `^https?:\/\/(?:[a-z0-9-]+\.)*?` + regexp.QuoteMeta(suggestion.Host) + `(?:[:/?#]|$)`,
+			}
+			byHost[suggestion.Host] = provider
+			providers = append(providers, provider)
+		}
+		provider.Rules = append(provider.Rules, regexp.QuoteMeta(suggestion.Key))
+	}
+	for _, provider := range providers {
+		slices.Sort(provider.Rules)
+	}
+	return marshalRulesJSON(providers)
+}
+
+// Human readable version of the suggestions, one key per line
+func (suggestions *Suggestions) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%d keys that look like tracking parameters in %d URLs\n", len(suggestions.Keys), suggestions.URLCount)
+	for _, suggestion := range suggestions.Keys {
+		fmt.Fprintf(&builder, "%.2f %s %s (seen %d times, %d values, %.0f bits",
+			suggestion.Score, suggestion.Host, suggestion.Key, suggestion.Occurrences, suggestion.DistinctValues, suggestion.MeanEntropy)
+		if suggestion.TrackerName {
+			builder.WriteString(", tracker name")
+		}
+		fmt.Fprintf(&builder, ") eg: %q\n", suggestion.Examples)
+	}
+	return builder.String()
+}
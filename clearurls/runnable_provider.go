@@ -4,6 +4,8 @@ package clearurls
 type RunnableProvider interface {
 	// Match semantics of matchURL @ https://github.com/ClearURLs/Addon/blob/master/clearurls.js#L404
 	matchURL(url string) (bool, error)
+	// Whether `urlPattern` matches, regardless of `exceptions`
+	matchURLPattern(url string) (bool, error)
 	// Return original (unique) name of this entry
 	getName() string
 	// Return `completeProvider` field of provider
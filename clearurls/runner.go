@@ -6,6 +6,7 @@ package clearurls
 import (
 	"fmt"
 	"net/url"
+	"slices"
 )
 
 // If a redirect in the provider matches, return that url
@@ -20,73 +21,119 @@ func getRedirect(provider RunnableProvider, urlToSearch string) (string, error)
 	return url.QueryUnescape(redirMatches[0][1])
 }
 
-func runProviderRuleOnValues(provider RunnableProvider, values url.Values, dontFilterReferrals bool) (string, error) {
+// Return the encoded `values` without the keys the provider filters, and those keys.
+// If `options.Redaction` is not `nil`, the keys are kept with their values masked instead.
+func runProviderRuleOnValues(provider RunnableProvider, values url.Values, host string, dontFilterReferrals bool, options *CleanOptions) (string, []string, error) {
 	keysToDelete := make([]string, 0, 3)
 	for key := range values {
+		if options.Overrides.keepsKey(host, key) {
+			continue
+		}
 		shouldFilter, err := provider.rulesKeyFilter(key, dontFilterReferrals)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		} else if shouldFilter {
 			keysToDelete = append(keysToDelete, key)
 		}
 	}
+	slices.Sort(keysToDelete) // Ranging over `values` is in random order
+	if options.Redaction != nil {
+		redacted := options.Redaction.redactValues(values, keysToDelete)
+		return values.Encode(), redacted, nil
+	}
 	for _, keyToDelete := range keysToDelete {
 		values.Del(keyToDelete)
 	}
-	return values.Encode(), nil
+	return values.Encode(), keysToDelete, nil
 }
 
 // Run on query then fragments. Order of Addon is not respected here, it does foreach rule { foreach [query, fragments] { apply() } }
-func runProviderRule(provider RunnableProvider, parsedURL *url.URL, dontFilterReferrals bool) error {
-	queryValues, err := runProviderRuleOnValues(provider, parsedURL.Query(), dontFilterReferrals)
+// Returns the removed, or redacted, keys.
+func runProviderRule(provider RunnableProvider, parsedURL *url.URL, options *CleanOptions) ([]string, error) {
+	host := parsedURL.Hostname()
+	dontFilterReferrals := options.Overrides.keepsReferrals(host, provider.getName(), options.KeepMarketingReferrals)
+	queryValues, removed, err := runProviderRuleOnValues(provider, parsedURL.Query(), host, dontFilterReferrals, options)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	parsedURL.RawQuery = queryValues
 	fragmentValues, err := url.ParseQuery(parsedURL.Fragment)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(fragmentValues) > 0 {
-		fragStr, err := runProviderRuleOnValues(provider, fragmentValues, dontFilterReferrals)
+		fragStr, fragmentRemoved, err := runProviderRuleOnValues(provider, fragmentValues, host, dontFilterReferrals, options)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		parsedURL.Fragment = fragStr
+		removed = append(removed, fragmentRemoved...)
 	}
-	return nil
+	return removed, nil
 }
 
-// Go through every provider (except if one returns a redirection), updating the URL
-func runProviders(providers []RunnableProvider, runningURL string, dontFilterReferrals bool) (string, error) {
+// Go through every provider (except if one returns a redirection), updating the URL.
+// If `trace` is not `nil`, what each provider did is appended to it.
+func runProviders(providers []RunnableProvider, runningURL string, options *CleanOptions, trace *CleanTrace) (string, error) {
 	// Equivalent to _cleaning @ https://github.com/ClearURLs/Addon/blob/master/core_js/pureCleaning.js#L43
 	for _, provider := range providers {
 		matched, err := provider.matchURL(runningURL)
 		if err != nil {
 			return "", err
 		}
+		if err := trace.addMatch(provider, runningURL, matched); err != nil {
+			return "", err
+		}
 		if !matched {
 			continue
 		}
 
 		if redirectionURL, err := getRedirect(provider, runningURL); err != nil || redirectionURL != "" {
+			if err == nil {
+				trace.addStep(CleanStep{Provider: provider.getName(), Before: runningURL, After: redirectionURL, Redirect: true})
+			}
 			return redirectionURL, err
 		}
 
 		if provider.isComplete() {
+			trace.addStep(CleanStep{Provider: provider.getName(), Before: runningURL, After: runningURL, Complete: true})
 			// Addon code contradicts doc at https://docs.clearurls.xyz/1.27.3/specs/rules/#completeprovider - redirections are processed before
 			continue
 		}
 
+		// As removeFieldsFormURL in https://github.com/ClearURLs/Addon/blob/master/clearurls.js, raw rules first
+		rawCleanedURL, err := provider.applyRawRules(runningURL)
+		if err != nil {
+			return "", err
+		}
+		if rawCleanedURL != runningURL {
+			trace.addStep(CleanStep{Provider: provider.getName(), Before: runningURL, After: rawCleanedURL, RawRules: true})
+			runningURL = rawCleanedURL
+		}
+
 		parsedURL, err := url.Parse(runningURL)
 		if err != nil {
 			return "", err
 		}
-		if err := runProviderRule(provider, parsedURL, dontFilterReferrals); err != nil {
+		removed, err := runProviderRule(provider, parsedURL, options)
+		if err != nil {
 			return "", err
 		}
 
-		runningURL = parsedURL.String()
+		cleanedURL := parsedURL.String()
+		if len(removed) > 0 && trace != nil {
+			step := CleanStep{Provider: provider.getName(), Before: runningURL, After: cleanedURL}
+			if options.Redaction != nil {
+				step.RedactedParameters = removed
+			} else {
+				step.RemovedParameters = removed
+			}
+			if step.ReferralParameters, err = referralParameters(provider, removed); err != nil {
+				return "", err
+			}
+			trace.addStep(step)
+		}
+		runningURL = cleanedURL
 	}
 	return runningURL, nil
 }
@@ -96,6 +143,9 @@ func runProviders(providers []RunnableProvider, runningURL string, dontFilterRef
 // If `keepMarketingReferrals` is `true`, parameters matching `referralMarketing`
 // regexen will be left included.
 //
+// As the addon does, each provider's `rawRules` are removed from the whole URL string
+// first, then the keys matching its `rules` from the query and fragment.
+//
 // To obtain a list of [RunnableProvider]: see [GetProvidersFromSourceArgument] for examples
 //
 // Example:
@@ -108,12 +158,36 @@ func runProviders(providers []RunnableProvider, runningURL string, dontFilterRef
 // [ClearURLs]: https://docs.clearurls.xyz/1.27.3/
 // [source]: https://github.com/ClearURLs/Addon
 func ClearURL(providers []RunnableProvider, url string, keepMarketingReferrals bool) (string, error) {
+	return clearURL(providers, url, &CleanOptions{KeepMarketingReferrals: keepMarketingReferrals}, nil)
+}
+
+// Options of [ClearURLWithOptions]
+type CleanOptions struct {
+	// Same as the `keepMarketingReferrals` argument of [ClearURL]. The default when
+	// [Overrides.KeepReferralsByHost] and [Overrides.KeepReferralsByProvider] don't say.
+	KeepMarketingReferrals bool
+	// If not `nil`, values of tracking parameters are masked instead of removed, see [ClearURLRedacted]
+	Redaction *Redaction
+	// If not `nil`, keys to keep and hosts to leave as is on top of the rules, see [Overrides].
+	// Used as is, see [Overrides.Validate].
+	Overrides *Overrides
+}
+
+// Same as [ClearURL], with more options
+func ClearURLWithOptions(providers []RunnableProvider, url string, options CleanOptions) (string, error) {
+	return clearURL(providers, url, &options, nil)
+}
+
+func clearURL(providers []RunnableProvider, url string, options *CleanOptions, trace *CleanTrace) (string, error) {
 	// Equivalent to pureCleaning @ https://github.com/ClearURLs/Addon/blob/master/core_js/pureCleaning.js#L28
 	var prev string
 	for changed := true; changed; changed = prev != url {
 		prev = url
+		if options.Overrides.excludesURL(url) {
+			break // Including after a redirection to an excluded host
+		}
 		var err error
-		url, err = runProviders(providers, url, keepMarketingReferrals)
+		url, err = runProviders(providers, url, options, trace)
 		if err != nil {
 			return "", err
 		}
@@ -0,0 +1,145 @@
+package clearurls
+
+// Report the tracking an URL contains, without cleaning it
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// A query or fragment parameter [ClearURL] would remove, see [AnalyzeURL]
+type TrackingParameter struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// `query` or `fragment`
+	Location string `json:"location"`
+	// Name of the provider whose rules match the key
+	Provider string `json:"provider"`
+	// `true` if the key is kept when keeping marketing referrals, see [ClearURL]
+	ReferralMarketing bool `json:"referralMarketing"`
+}
+
+// A redirection [ClearURL] would follow
+type TrackingRedirection struct {
+	Provider string `json:"provider"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// Parts of an URL the `rawRules` of a provider would remove, see [AnalyzeURL]
+type TrackingRawRules struct {
+	Provider string `json:"provider"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// The tracking found in an URL by [AnalyzeURL]
+type URLAnalysis struct {
+	URL string `json:"url"`
+	// What [ClearURLWithOptions] returns for `URL`, with the options of [AnalyzeURL]
+	Cleaned      string                `json:"cleaned"`
+	Parameters   []TrackingParameter   `json:"parameters"`
+	Redirections []TrackingRedirection `json:"redirections"`
+	RawRules     []TrackingRawRules    `json:"rawRules"`
+	// Names of the `completeProvider` providers matching the URL
+	BlockedBy []string `json:"blockedBy"`
+}
+
+// Return what tracking `rawURL` contains as per `providers`: the parameters that would be
+// removed (or masked, with [CleanOptions.Redaction]) and by which provider, the redirections
+// that would be followed, the URLs `rawRules` would edit, and the `completeProvider` providers
+// that match, all as per `options`.
+// `rawURL` itself is left as is.
+func AnalyzeURL(providers []RunnableProvider, rawURL string, options CleanOptions) (*URLAnalysis, error) {
+	trace, err := clearURLWithTrace(providers, rawURL, &options)
+	if err != nil {
+		return nil, err
+	}
+	analysis := &URLAnalysis{
+		URL:          rawURL,
+		Cleaned:      trace.Output,
+		Parameters:   []TrackingParameter{},
+		Redirections: []TrackingRedirection{},
+		RawRules:     []TrackingRawRules{},
+		BlockedBy:    []string{},
+	}
+	for _, step := range trace.Steps {
+		switch {
+		case step.Redirect:
+			analysis.Redirections = append(analysis.Redirections, TrackingRedirection{Provider: step.Provider, From: step.Before, To: step.After})
+		case step.RawRules:
+			analysis.RawRules = append(analysis.RawRules, TrackingRawRules{Provider: step.Provider, From: step.Before, To: step.After})
+		case step.Complete:
+			if !slices.Contains(analysis.BlockedBy, step.Provider) {
+				analysis.BlockedBy = append(analysis.BlockedBy, step.Provider)
+			}
+		default:
+			parameters, err := removedParameters(step)
+			if err != nil {
+				return nil, err
+			}
+			analysis.Parameters = append(analysis.Parameters, parameters...)
+		}
+	}
+	return analysis, nil
+}
+
+// Return the parameters removed or masked by `step`, with their values in its `Before` URL
+func removedParameters(step CleanStep) ([]TrackingParameter, error) {
+	parsedURL, err := url.Parse(step.Before)
+	if err != nil {
+		return nil, err
+	}
+	fragmentValues, _ := url.ParseQuery(parsedURL.Fragment)
+	locations := []struct {
+		name   string
+		values url.Values
+	}{
+		{"query", parsedURL.Query()},
+		{"fragment", fragmentValues},
+	}
+	parameters := []TrackingParameter{}
+	keys := slices.Compact(slices.Sorted(slices.Values(slices.Concat(step.RemovedParameters, step.RedactedParameters))))
+	for _, key := range keys {
+		for _, location := range locations {
+			for _, value := range location.values[key] {
+				parameters = append(parameters, TrackingParameter{
+					Key:               key,
+					Value:             value,
+					Location:          location.name,
+					Provider:          step.Provider,
+					ReferralMarketing: slices.Contains(step.ReferralParameters, key),
+				})
+			}
+		}
+	}
+	return parameters, nil
+}
+
+// Human readable version of the analysis
+func (analysis *URLAnalysis) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s\n", analysis.URL)
+	for _, redirection := range analysis.Redirections {
+		fmt.Fprintf(&builder, "  redirection by %s to %s\n", redirection.Provider, redirection.To)
+	}
+	for _, rawRules := range analysis.RawRules {
+		fmt.Fprintf(&builder, "  raw rules of %s to %s\n", rawRules.Provider, rawRules.To)
+	}
+	for _, parameter := range analysis.Parameters {
+		referral := ""
+		if parameter.ReferralMarketing {
+			referral = " (referral marketing)"
+		}
+		fmt.Fprintf(&builder, "  %s %s=%q by %s%s\n", parameter.Location, parameter.Key, parameter.Value, parameter.Provider, referral)
+	}
+	for _, provider := range analysis.BlockedBy {
+		fmt.Fprintf(&builder, "  blocked by %s\n", provider)
+	}
+	if len(analysis.Redirections) == 0 && len(analysis.RawRules) == 0 && len(analysis.Parameters) == 0 && len(analysis.BlockedBy) == 0 {
+		builder.WriteString("  no tracking found\n")
+	}
+	return builder.String()
+}
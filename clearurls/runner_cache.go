@@ -0,0 +1,149 @@
+package clearurls
+
+// Memoize `ClearURL` results in a bounded LRU, for services that see the same
+// URLs over and over
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Key of one memoized result: the input URL and the options it was cleaned with
+type cachedCleanerKey struct {
+	url                    string
+	keepMarketingReferrals bool
+}
+
+type cachedCleanerEntry struct {
+	key     cachedCleanerKey
+	cleaned string
+}
+
+// Counters of a [CachedCleaner], see [CachedCleaner.Stats]
+type CachedCleanerStats struct {
+	Hits, Misses uint64
+	// Number of results currently memoized
+	Entries int
+}
+
+// Wraps [ClearURL] with a bounded, least recently used, memoization of results.
+// Safe for concurrent use.
+//
+// Results are keyed by input URL and options. Errors are not memoized.
+// Swapping the providers with [CachedCleaner.SetProviders] drops all results.
+//
+// Example:
+//
+//	providers, err := clearurls.MustHaveHardcodedProviders()
+//	// if err != nil ....
+//	cleaner := clearurls.NewCachedCleaner(providers, 10000)
+//	clearedURL, err := cleaner.ClearURL("http://example.com?eviltrackytracktrack=true", false)
+type CachedCleaner struct {
+	mutex      sync.Mutex
+	providers  []RunnableProvider
+	generation uint64 // Incremented each time `providers` are swapped
+	maxEntries int
+	entries    map[cachedCleanerKey]*list.Element
+	recency    *list.List // Most recently used at the front
+	hits       uint64
+	misses     uint64
+}
+
+// Create a [CachedCleaner] running `providers`, keeping at most `maxEntries` results.
+// If `maxEntries` is less than 1, nothing is memoized.
+func NewCachedCleaner(providers []RunnableProvider, maxEntries int) *CachedCleaner {
+	return &CachedCleaner{
+		providers:  providers,
+		maxEntries: maxEntries,
+		entries:    make(map[cachedCleanerKey]*list.Element),
+		recency:    list.New(),
+	}
+}
+
+// Same as [ClearURL] with the providers of this cleaner, but returns the
+// memoized result if this `url` was already cleaned with the same options
+func (cleaner *CachedCleaner) ClearURL(url string, keepMarketingReferrals bool) (string, error) {
+	key := cachedCleanerKey{url: url, keepMarketingReferrals: keepMarketingReferrals}
+	cleaner.mutex.Lock()
+	if element, ok := cleaner.entries[key]; ok {
+		cleaner.recency.MoveToFront(element)
+		cleaner.hits++
+		cleaned := element.Value.(*cachedCleanerEntry).cleaned
+		cleaner.mutex.Unlock()
+		return cleaned, nil
+	}
+	cleaner.misses++
+	providers, generation := cleaner.providers, cleaner.generation
+	cleaner.mutex.Unlock()
+
+	cleaned, err := ClearURL(providers, url, keepMarketingReferrals)
+	if err != nil {
+		return "", err
+	}
+
+	cleaner.mutex.Lock()
+	defer cleaner.mutex.Unlock()
+	if generation == cleaner.generation {
+		cleaner.store(key, cleaned)
+	}
+	return cleaned, nil
+}
+
+// Must be called with the lock held
+func (cleaner *CachedCleaner) store(key cachedCleanerKey, cleaned string) {
+	if cleaner.maxEntries < 1 {
+		return
+	}
+	if element, ok := cleaner.entries[key]; ok {
+		// Another goroutine cleaned the same URL concurrently
+		cleaner.recency.MoveToFront(element)
+		return
+	}
+	cleaner.entries[key] = cleaner.recency.PushFront(&cachedCleanerEntry{key: key, cleaned: cleaned})
+	for cleaner.recency.Len() > cleaner.maxEntries {
+		oldest := cleaner.recency.Back()
+		cleaner.recency.Remove(oldest)
+		delete(cleaner.entries, oldest.Value.(*cachedCleanerEntry).key)
+	}
+}
+
+// Replace the providers used to clean URLs, and drop all memoized results.
+// Cleanings already running with the previous providers are not memoized.
+func (cleaner *CachedCleaner) SetProviders(providers []RunnableProvider) {
+	cleaner.mutex.Lock()
+	defer cleaner.mutex.Unlock()
+	cleaner.providers = providers
+	cleaner.generation++
+	cleaner.purge()
+}
+
+// Return the providers currently used to clean URLs
+func (cleaner *CachedCleaner) Providers() []RunnableProvider {
+	cleaner.mutex.Lock()
+	defer cleaner.mutex.Unlock()
+	return cleaner.providers
+}
+
+// Drop all memoized results, keeping the counters
+func (cleaner *CachedCleaner) Purge() {
+	cleaner.mutex.Lock()
+	defer cleaner.mutex.Unlock()
+	cleaner.purge()
+}
+
+// Must be called with the lock held
+func (cleaner *CachedCleaner) purge() {
+	clear(cleaner.entries)
+	cleaner.recency.Init()
+}
+
+// Return the hit and miss counters, and the number of memoized results
+func (cleaner *CachedCleaner) Stats() CachedCleanerStats {
+	cleaner.mutex.Lock()
+	defer cleaner.mutex.Unlock()
+	return CachedCleanerStats{
+		Hits:    cleaner.hits,
+		Misses:  cleaner.misses,
+		Entries: cleaner.recency.Len(),
+	}
+}
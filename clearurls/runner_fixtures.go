@@ -0,0 +1,203 @@
+package clearurls
+
+// Run a shared corpus of URLs with their expected results, eg: as a regression suite
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// One URL to clean and its expected result, see [RunURLFixtures]
+type URLFixture struct {
+	// Optional, shown in reports instead of `Input`
+	Name  string `json:"name,omitempty"`
+	Input string `json:"input"`
+	// What [ClearURL] returns for `Input`
+	Expected string `json:"expected,omitempty"`
+	// `true` if `Input` matches a `completeProvider`, instead of checking `Expected`
+	Blocked bool `json:"blocked,omitempty"`
+	// Passed to [ClearURL]
+	KeepMarketingReferrals bool `json:"keepMarketingReferrals,omitempty"`
+	// Set by [RecordURLFixtures]: providers that changed `Input`, and SHA-256 of the rules used
+	Providers   []string `json:"providers,omitempty"`
+	RulesSHA256 string   `json:"rulesSHA256,omitempty"`
+}
+
+// Name of the fixture in reports
+func (fixture *URLFixture) displayName() string {
+	if fixture.Name != "" {
+		return fixture.Name
+	}
+	return fixture.Input
+}
+
+// Outcome of one [URLFixture]
+type FixtureResult struct {
+	Fixture URLFixture `json:"fixture"`
+	Passed  bool       `json:"passed"`
+	// What [ClearURL] returned
+	Got string `json:"got"`
+	// Whether a `completeProvider` matched
+	GotBlocked bool `json:"gotBlocked,omitempty"`
+	// Error returned by [ClearURL], if any
+	Err string `json:"error,omitempty"`
+	// Providers that changed the input, see [CleanTrace.Providers]
+	Providers []string      `json:"providers,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Outcome of [RunURLFixtures]
+type FixturesReport struct {
+	Passed   int             `json:"passed"`
+	Failed   int             `json:"failed"`
+	Duration time.Duration   `json:"duration"`
+	Results  []FixtureResult `json:"results"`
+}
+
+// Read fixtures from `reader`, either a JSON array of [URLFixture] or one per line (NDJSON)
+func ReadURLFixtures(reader io.Reader) ([]URLFixture, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	fixtures := []URLFixture{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &fixtures); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var fixture URLFixture
+			if err := decoder.Decode(&fixture); err != nil {
+				return nil, fmt.Errorf("%w: fixture %d: %w", ErrInvalidJSON, len(fixtures)+1, err)
+			}
+			fixtures = append(fixtures, fixture)
+		}
+	}
+	for i, fixture := range fixtures {
+		if fixture.Input == "" {
+			return nil, fmt.Errorf("fixture %d has no input", i+1)
+		}
+	}
+	return fixtures, nil
+}
+
+// Clean `input` and compare with `expected`, or if `blocked`, check a `completeProvider` matched
+func checkURLExpectation(providers []RunnableProvider, input, expected string, blocked, keepMarketingReferrals bool) (result FixtureResult) {
+	trace, err := ClearURLWithTrace(providers, input, keepMarketingReferrals)
+	result.Got = trace.Output
+	result.Providers = trace.Providers()
+	result.GotBlocked = slices.ContainsFunc(trace.Steps, func(step CleanStep) bool { return step.Complete })
+	if err != nil {
+		result.Err = err.Error()
+	} else if blocked {
+		result.Passed = result.GotBlocked
+	} else {
+		result.Passed = result.Got == expected
+	}
+	return result
+}
+
+// Clean the input of each of `fixtures` with `providers`, and compare with their expected result
+func RunURLFixtures(providers []RunnableProvider, fixtures []URLFixture) *FixturesReport {
+	report := &FixturesReport{Results: make([]FixtureResult, len(fixtures))}
+	start := time.Now()
+	for i, fixture := range fixtures {
+		fixtureStart := time.Now()
+		result := checkURLExpectation(providers, fixture.Input, fixture.Expected, fixture.Blocked, fixture.KeepMarketingReferrals)
+		result.Fixture = fixture
+		result.Duration = time.Since(fixtureStart)
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Results[i] = result
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+// Why `result` failed, empty if it passed
+func (result *FixtureResult) failureMessage() string {
+	switch {
+	case result.Passed:
+		return ""
+	case result.Err != "":
+		return result.Err
+	case result.Fixture.Blocked:
+		return fmt.Sprintf("expected blocked, got %q", result.Got)
+	default:
+		return fmt.Sprintf("expected %q, got %q", result.Fixture.Expected, result.Got)
+	}
+}
+
+// Human readable version of the report: failures, then totals
+func (report *FixturesReport) String() string {
+	var builder strings.Builder
+	for _, result := range report.Results {
+		if !result.Passed {
+			fmt.Fprintf(&builder, "FAIL %s\n     %s\n", result.Fixture.displayName(), result.failureMessage())
+		}
+	}
+	fmt.Fprintf(&builder, "%d passed, %d failed (%s)\n", report.Passed, report.Failed, report.Duration.Round(time.Microsecond))
+	return builder.String()
+}
+
+// Write the report as a JUnit XML test suite named `suiteName`, for CI tools
+func (report *FixturesReport) WriteJUnit(writer io.Writer, suiteName string) error {
+	type junitMessage struct {
+		Message string `xml:"message,attr"`
+	}
+	type junitTestCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      float64       `xml:"time,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Error     *junitMessage `xml:"error,omitempty"`
+	}
+	type junitTestSuite struct {
+		XMLName   xml.Name        `xml:"testsuite"`
+		Name      string          `xml:"name,attr"`
+		Tests     int             `xml:"tests,attr"`
+		Failures  int             `xml:"failures,attr"`
+		Errors    int             `xml:"errors,attr"`
+		Time      float64         `xml:"time,attr"`
+		TestCases []junitTestCase `xml:"testcase"`
+	}
+	suite := junitTestSuite{
+		Name:      suiteName,
+		Tests:     len(report.Results),
+		Time:      report.Duration.Seconds(),
+		TestCases: make([]junitTestCase, len(report.Results)),
+	}
+	for i, result := range report.Results {
+		testCase := junitTestCase{Name: result.Fixture.displayName(), ClassName: suiteName, Time: result.Duration.Seconds()}
+		switch {
+		case result.Err != "":
+			testCase.Error = &junitMessage{Message: result.failureMessage()}
+			suite.Errors++
+		case !result.Passed:
+			testCase.Failure = &junitMessage{Message: result.failureMessage()}
+			suite.Failures++
+		}
+		suite.TestCases[i] = testCase
+	}
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, "\n")
+	return err
+}
@@ -0,0 +1,157 @@
+package clearurls
+
+// Freeze cleaning results as fixtures, and explain later changes with the rules diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Clean each of `urls` with `ruleSet`, and return fixtures expecting the results,
+// with the providers responsible and the SHA-256 of the rules
+func RecordURLFixtures(ruleSet *RuleSet, urls []string, keepMarketingReferrals bool) ([]URLFixture, error) {
+	fixtures := make([]URLFixture, len(urls))
+	for i, url := range urls {
+		trace, err := ClearURLWithTrace(ruleSet.Providers, url, keepMarketingReferrals)
+		if err != nil {
+			return nil, fmt.Errorf("URL %q: %w", truncateForError(url), err)
+		}
+		fixtures[i] = URLFixture{
+			Input:                  url,
+			Expected:               trace.Output,
+			KeepMarketingReferrals: keepMarketingReferrals,
+			Providers:              trace.Providers(),
+			RulesSHA256:            ruleSet.SHA256,
+		}
+	}
+	return fixtures, nil
+}
+
+// Write `fixtures` to `writer`, one per line if `ndjson`, as an indented JSON array otherwise.
+// Either can be read back with [ReadURLFixtures].
+func WriteURLFixtures(writer io.Writer, fixtures []URLFixture, ndjson bool) error {
+	encoder := json.NewEncoder(writer)
+	if !ndjson {
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(fixtures)
+	}
+	for _, fixture := range fixtures {
+		if err := encoder.Encode(fixture); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Why a recorded fixture fails now, see [CheckURLFixtures]
+type FixtureExplanation struct {
+	Input          string `json:"input"`
+	RecordedSHA256 string `json:"recordedSHA256"`
+	// Changes to the providers that changed the URL when recorded or now, `nil` if unknown
+	Diff *RuleSetDiff `json:"diff,omitempty"`
+	// Why `Diff` is unknown, eg: the recorded rules are not in the cache anymore
+	Unknown string `json:"unknown,omitempty"`
+}
+
+// Outcome of [CheckURLFixtures]
+type FixturesCheckReport struct {
+	FixturesReport
+	RulesSHA256 string `json:"rulesSHA256"`
+	// One for each failing fixture
+	Explanations []FixtureExplanation `json:"explanations"`
+}
+
+// Run `fixtures` recorded with [RecordURLFixtures] with `ruleSet`, and for each that
+// fails, explain it with the changes since the rules it was recorded with. Those are
+// looked up in `snapshots`, that can be `nil` (see [GetSnapshotsFromSourceArgument]).
+func CheckURLFixtures(ruleSet *RuleSet, fixtures []URLFixture, snapshots *Snapshots) *FixturesCheckReport {
+	report := &FixturesCheckReport{
+		FixturesReport: *RunURLFixtures(ruleSet.Providers, fixtures),
+		RulesSHA256:    ruleSet.SHA256,
+		Explanations:   []FixtureExplanation{},
+	}
+	type diffOrError struct {
+		diff *RuleSetDiff
+		err  error
+	}
+	diffs := map[string]diffOrError{} // By recorded SHA-256
+	for _, result := range report.Results {
+		if result.Passed {
+			continue
+		}
+		explanation := FixtureExplanation{Input: result.Fixture.Input, RecordedSHA256: result.Fixture.RulesSHA256}
+		switch {
+		case explanation.RecordedSHA256 == "":
+			explanation.Unknown = "not recorded with known rules"
+		case strings.EqualFold(explanation.RecordedSHA256, ruleSet.SHA256):
+			explanation.Unknown = "rules are unchanged since recorded"
+		default:
+			cached, ok := diffs[explanation.RecordedSHA256]
+			if !ok {
+				cached.diff, cached.err = diffSinceSnapshot(ruleSet, snapshots, explanation.RecordedSHA256)
+				diffs[explanation.RecordedSHA256] = cached
+			}
+			if cached.err != nil {
+				explanation.Unknown = cached.err.Error()
+				break
+			}
+			involved := slices.Concat(result.Fixture.Providers, result.Providers)
+			explanation.Diff = cached.diff.only(func(name string) bool { return slices.Contains(involved, name) })
+		}
+		report.Explanations = append(report.Explanations, explanation)
+	}
+	return report
+}
+
+func diffSinceSnapshot(ruleSet *RuleSet, snapshots *Snapshots, sum string) (*RuleSetDiff, error) {
+	if snapshots == nil {
+		return nil, fmt.Errorf("recorded rules %s are unavailable without a cache", sum)
+	}
+	entry, err := snapshots.Get(sum)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := newRuleSetFromEntry(entry, true)
+	if err != nil {
+		return nil, err
+	}
+	return DiffRuleSets(recorded, ruleSet)
+}
+
+// Return a copy of the diff with only the providers for which `keep` returns `true`
+func (diff *RuleSetDiff) only(keep func(name string) bool) *RuleSetDiff {
+	result := *diff
+	result.Added = slices.DeleteFunc(slices.Clone(diff.Added), func(name string) bool { return !keep(name) })
+	result.Removed = slices.DeleteFunc(slices.Clone(diff.Removed), func(name string) bool { return !keep(name) })
+	result.Modified = slices.DeleteFunc(slices.Clone(diff.Modified), func(provider ProviderDiff) bool { return !keep(provider.Name) })
+	return &result
+}
+
+// Human readable version of the report: failures with their explanation, then totals
+func (report *FixturesCheckReport) String() string {
+	var builder strings.Builder
+	explanations := 0
+	for _, result := range report.Results {
+		if result.Passed {
+			continue
+		}
+		fmt.Fprintf(&builder, "FAIL %s\n     %s\n", result.Fixture.displayName(), result.failureMessage())
+		explanation := report.Explanations[explanations]
+		explanations++
+		switch {
+		case explanation.Unknown != "":
+			fmt.Fprintf(&builder, "     (%s)\n", explanation.Unknown)
+		case explanation.Diff.IsEmpty():
+			fmt.Fprintf(&builder, "     (no change to the providers involved since %s)\n", explanation.RecordedSHA256)
+		default:
+			for _, line := range strings.Split(strings.TrimSuffix(explanation.Diff.String(), "\n"), "\n") {
+				fmt.Fprintf(&builder, "     %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(&builder, "%d passed, %d failed against %s\n", report.Passed, report.Failed, report.RulesSHA256)
+	return builder.String()
+}
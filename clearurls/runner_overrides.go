@@ -0,0 +1,203 @@
+package clearurls
+
+// Keys to keep, hosts to leave as is, and where to keep referral marketing on top of
+// the rules, eg: when a generic rule breaks one of our own apps
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Exceptions to the rules, configured separately from the ClearURLs data, see [CleanOptions].
+// Can be read from JSON with [ReadOverrides], eg:
+//
+//	{
+//	  "keepKeys": ["lang"],
+//	  "keepKeysByHost": {"wiki.example.com": ["ref"]},
+//	  "excludedHosts": ["intranet.example.com"],
+//	  "excludedDomains": ["example.co.uk"],
+//	  "keepReferralsByHost": {"partner.example.net": true},
+//	  "keepReferralsByProvider": {"amazon": false}
+//	}
+//
+// Hosts are matched case-insensitively, along with their subdomains: `example.com`
+// also matches `www.example.com`. IP addresses only match themselves. Public suffixes
+// like `co.uk` or `github.io` are refused, as they would match unrelated sites.
+type Overrides struct {
+	// Keys never removed, on any host. Matched case-insensitively, as the rules are.
+	KeepKeys []string `json:"keepKeys,omitempty"`
+	// Keys never removed on a host, by host
+	KeepKeysByHost map[string][]string `json:"keepKeysByHost,omitempty"`
+	// URLs on these hosts are left as is, and so are redirections to them
+	ExcludedHosts []string `json:"excludedHosts,omitempty"`
+	// Same as `ExcludedHosts`, for the whole registrable domain (eTLD+1) of each entry:
+	// `www.example.co.uk` excludes `shop.example.co.uk` too
+	ExcludedDomains []string `json:"excludedDomains,omitempty"`
+	// Whether to keep parameters matching `referralMarketing` on a host, by host, instead of
+	// [CleanOptions.KeepMarketingReferrals]. The longest host matching wins, eg: to keep
+	// referrals on `partner.com` but not `shop.partner.com`.
+	KeepReferralsByHost map[string]bool `json:"keepReferralsByHost,omitempty"`
+	// Same as `KeepReferralsByHost`, by provider name, for hosts not in `KeepReferralsByHost`.
+	// See [Overrides.CheckProviders] to catch names that match no provider.
+	KeepReferralsByProvider map[string]bool `json:"keepReferralsByProvider,omitempty"`
+}
+
+// Read and check [Overrides] from JSON
+func ReadOverrides(reader io.Reader) (*Overrides, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	overrides := &Overrides{}
+	if err := decoder.Decode(overrides); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+	}
+	return overrides, overrides.Validate()
+}
+
+// Return an error if a host is empty or a public suffix. Done by [ReadOverrides], but not
+// when cleaning URLs, so call it once on overrides built otherwise.
+func (overrides *Overrides) Validate() error {
+	if overrides == nil {
+		return nil
+	}
+	hosts := slices.Concat(overrides.ExcludedHosts, overrides.ExcludedDomains)
+	for host := range overrides.KeepKeysByHost {
+		hosts = append(hosts, host)
+	}
+	for host := range overrides.KeepReferralsByHost {
+		hosts = append(hosts, host)
+	}
+	for _, host := range hosts {
+		if err := checkOverrideHost(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return an error if `KeepReferralsByProvider` names providers missing from `providers`,
+// eg: misspelt or renamed upstream, as they would be silently ignored
+func (overrides *Overrides) CheckProviders(providers []RunnableProvider) error {
+	if overrides == nil {
+		return nil
+	}
+	unknown := []string{}
+	for name := range overrides.KeepReferralsByProvider {
+		if !slices.ContainsFunc(providers, func(provider RunnableProvider) bool { return provider.getName() == name }) {
+			unknown = append(unknown, fmt.Sprintf("%q", name))
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("no provider named %s in the rules, for keepReferralsByProvider in overrides", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func checkOverrideHost(host string) error {
+	host = normalizeHost(host)
+	if host == "" {
+		return fmt.Errorf("empty host in overrides")
+	}
+	if net.ParseIP(host) != nil {
+		return nil // Not a domain, has no public suffix
+	}
+	// Hosts unknown to the list, eg: `localhost`, are their own suffix without being ICANN's
+	suffix, icann := publicsuffix.PublicSuffix(host)
+	if suffix == host && (icann || strings.Contains(host, ".")) {
+		return fmt.Errorf("host %q in overrides is a public suffix, it would match unrelated sites", host)
+	}
+	return nil
+}
+
+// Lowercase `host`, without trailing dot, nor brackets around IPv6 addresses as in URLs
+func normalizeHost(host string) string {
+	return strings.Trim(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), "."), "[]")
+}
+
+// `true` if `host` is `pattern` or one of its subdomains, or the IP address `pattern`
+func hostMatches(host, pattern string) bool {
+	pattern = normalizeHost(pattern)
+	if net.ParseIP(pattern) != nil {
+		return host == pattern
+	}
+	return pattern != "" && (host == pattern || strings.HasSuffix(host, "."+pattern))
+}
+
+// Registrable domain of `host`, or `host` itself if it has none, eg: `localhost` or an IP address
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// `true` if the providers should not touch `rawURL` at all
+func (overrides *Overrides) excludesURL(rawURL string) bool {
+	if overrides == nil || len(overrides.ExcludedHosts)+len(overrides.ExcludedDomains) == 0 {
+		return false
+	}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return false // Reported by the providers
+	}
+	host := normalizeHost(parsedURL.Hostname())
+	if slices.ContainsFunc(overrides.ExcludedHosts, func(pattern string) bool { return hostMatches(host, pattern) }) {
+		return true
+	}
+	domain := registrableDomain(host)
+	return slices.ContainsFunc(overrides.ExcludedDomains, func(pattern string) bool {
+		return domain == registrableDomain(normalizeHost(pattern))
+	})
+}
+
+// `true` if `key` must not be removed from URLs on `host`
+func (overrides *Overrides) keepsKey(host, key string) bool {
+	if overrides == nil {
+		return false
+	}
+	isKey := func(kept string) bool { return strings.EqualFold(kept, key) }
+	if slices.ContainsFunc(overrides.KeepKeys, isKey) {
+		return true
+	}
+	host = normalizeHost(host)
+	for pattern, keys := range overrides.KeepKeysByHost {
+		if hostMatches(host, pattern) && slices.ContainsFunc(keys, isKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether `provider` keeps parameters matching `referralMarketing` on `host`,
+// `defaultKeep` unless overridden
+func (overrides *Overrides) keepsReferrals(host, provider string, defaultKeep bool) bool {
+	if overrides == nil {
+		return defaultKeep
+	}
+	host = normalizeHost(host)
+	longest := ""
+	keep, found := false, false
+	for pattern, keepOnHost := range overrides.KeepReferralsByHost {
+		if hostMatches(host, pattern) && len(normalizeHost(pattern)) > len(longest) {
+			longest, keep, found = normalizeHost(pattern), keepOnHost, true
+		}
+	}
+	if found {
+		return keep
+	}
+	if keep, ok := overrides.KeepReferralsByProvider[provider]; ok {
+		return keep
+	}
+	return defaultKeep
+}
@@ -0,0 +1,94 @@
+package clearurls
+
+// Mask the values of tracking parameters instead of removing them, eg: to keep the
+// shape of URLs for analytics without storing identifiers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// Value of redacted parameters when [Redaction.Placeholder] is empty
+const DefaultRedactionPlaceholder = "redacted"
+
+// Number of hex digits of the salted hash of a redacted value, see [Redaction.Salt]
+const redactionHashLength = 16
+
+// How [ClearURLRedacted] masks the values of tracking parameters
+type Redaction struct {
+	// Replaces each value, [DefaultRedactionPlaceholder] if empty
+	Placeholder string
+	// If not empty, each value is replaced with `<Placeholder>-<hash>` instead, where
+	// `hash` is the start of its HMAC-SHA256 keyed with `Salt`: equal values stay equal,
+	// without being recoverable from URLs alone
+	Salt []byte
+}
+
+func (redaction *Redaction) placeholder() string {
+	if redaction.Placeholder == "" {
+		return DefaultRedactionPlaceholder
+	}
+	return redaction.Placeholder
+}
+
+// `true` if `value` is already the result of [Redaction.redact]. As URLs are cleaned
+// until they stop changing, redacting must not change redacted values again.
+func (redaction *Redaction) isRedacted(value string) bool {
+	placeholder := redaction.placeholder()
+	if value == placeholder {
+		return true
+	}
+	hash, ok := strings.CutPrefix(value, placeholder+"-")
+	if !ok || len(hash) != redactionHashLength {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func (redaction *Redaction) redact(value string) string {
+	if len(redaction.Salt) == 0 {
+		return redaction.placeholder()
+	}
+	mac := hmac.New(sha256.New, redaction.Salt)
+	mac.Write([]byte(value))
+	return redaction.placeholder() + "-" + hex.EncodeToString(mac.Sum(nil))[:redactionHashLength]
+}
+
+// Mask the values of `keys` in `values`, and return the keys that had values not masked yet
+func (redaction *Redaction) redactValues(values url.Values, keys []string) []string {
+	redacted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		changed := false
+		for i, value := range values[key] {
+			if !redaction.isRedacted(value) {
+				values[key][i] = redaction.redact(value)
+				changed = true
+			}
+		}
+		if changed {
+			redacted = append(redacted, key)
+		}
+	}
+	return redacted
+}
+
+// Same as [ClearURL], but parameters matching `rules` (and `referralMarketing`, unless
+// `keepMarketingReferrals`) are kept with their values masked as per `redaction`.
+// Redirections, `rawRules` and `completeProvider` providers apply as usual, so what
+// `rawRules` match is removed rather than masked.
+//
+// Example:
+//
+//	redaction := &clearurls.Redaction{Salt: []byte("not so secret")}
+//	clearedURL, err := clearurls.ClearURLRedacted(providers, "http://example.com?fbclid=1234", false, redaction)
+//	// clearedURL is "http://example.com?fbclid=redacted-..."
+func ClearURLRedacted(providers []RunnableProvider, url string, keepMarketingReferrals bool, redaction *Redaction) (string, error) {
+	if redaction == nil {
+		redaction = &Redaction{}
+	}
+	return clearURL(providers, url, &CleanOptions{KeepMarketingReferrals: keepMarketingReferrals, Redaction: redaction}, nil)
+}
@@ -0,0 +1,316 @@
+package clearurls
+
+// Count which providers and which of their rules fire on a corpus of URLs, eg: to
+// trim rules that never do
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Counts for one rule (an entry of `rules`), see [ProviderStats]
+type RuleHits struct {
+	// Number of keys removed by this rule
+	Removed int `json:"removed"`
+	// Number of times each key was removed
+	Keys map[string]int `json:"keys"`
+}
+
+// Counts for one provider, see [RuleStats]. All entries of the provider are present,
+// including those that never fired.
+type ProviderStats struct {
+	// URLs the provider ran on, matching `urlPattern` and none of the `exceptions`, either
+	// themselves or once redirected
+	Matches int `json:"matches"`
+	// URLs left untouched because this is a `completeProvider`
+	Blocks int `json:"blocks"`
+	// By entry of `rules`
+	Rules map[string]*RuleHits `json:"rules"`
+	// By entry of `referralMarketing`, number of keys removed that it matches, and that
+	// would be kept when keeping marketing referrals
+	ReferralMarketing map[string]int `json:"referralMarketing"`
+	// By entry of `redirections`, number of redirections taken
+	Redirections map[string]int `json:"redirections"`
+	// By entry of `exceptions`, number of URLs matching `urlPattern` it prevented the provider to run on
+	Exceptions map[string]int `json:"exceptions"`
+}
+
+// What a [StatsCollector] counted
+type RuleStats struct {
+	RulesSHA256 string `json:"rulesSHA256"`
+	// Number of URLs cleaned
+	URLCount int `json:"urlCount"`
+	// Number of those with a different result
+	ChangedCount int `json:"changedCount"`
+	// Number of those that failed to be cleaned
+	ErrorCount int                       `json:"errorCount"`
+	Providers  map[string]*ProviderStats `json:"providers"`
+}
+
+// Entries of a provider compiled one by one, to tell which one fired
+type statsProvider struct {
+	rules             []*regexp.Regexp
+	referralMarketing []*regexp.Regexp
+	exceptions        []*regexp.Regexp
+	redirections      []*regexp.Regexp
+	definition        *providerJSON
+}
+
+// Wraps [ClearURL] to count which providers and rules fire. Safe for concurrent use.
+//
+// Example:
+//
+//	ruleSet, err := clearurls.GetRuleSetFromSourceArgument("github:@")
+//	// if err != nil ....
+//	collector, err := clearurls.NewStatsCollector(ruleSet)
+//	// if err != nil ....
+//	clearedURL, err := collector.ClearURL("http://example.com?eviltrackytracktrack=true", false)
+//	stats := collector.Stats()
+type StatsCollector struct {
+	ruleSet   *RuleSet
+	providers map[string]*statsProvider
+
+	mutex sync.Mutex
+	stats *RuleStats
+}
+
+// Create a collector cleaning URLs with `ruleSet`, which needs its rules JSON, as
+// for all rule sets obtained from this package
+func NewStatsCollector(ruleSet *RuleSet) (*StatsCollector, error) {
+	definitions, err := ruleSet.jsonDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	collector := &StatsCollector{
+		ruleSet:   ruleSet,
+		providers: make(map[string]*statsProvider, len(definitions)),
+	}
+	for _, definition := range definitions {
+		provider := &statsProvider{definition: definition}
+		if provider.rules, err = compileStatsRegexps(definition, "rules", definition.Rules, "^", "$"); err != nil {
+			return nil, err
+		}
+		if provider.referralMarketing, err = compileStatsRegexps(definition, "referralMarketing", definition.ReferralMarketing, "", ""); err != nil {
+			return nil, err
+		}
+		if provider.exceptions, err = compileStatsRegexps(definition, "exceptions", definition.Exceptions, "", ""); err != nil {
+			return nil, err
+		}
+		if provider.redirections, err = compileStatsRegexps(definition, "redirections", definition.Redirections, "", ""); err != nil {
+			return nil, err
+		}
+		collector.providers[definition.name] = provider
+	}
+	collector.Reset()
+	return collector, nil
+}
+
+// Compile one entry of a list on its own, as the runner does within the alternation of
+// all of them, so that an empty entry matches everything
+func compileStatsRegexp(provider *providerJSON, field, pattern, prefix, suffix string) (*regexp.Regexp, error) {
+	rx, err := regexp.Compile(caseInsensitiveRXStrPrefix + prefix + pattern + suffix)
+	if err != nil {
+		return nil, &CompileError{Provider: provider.name, Field: field, Err: err}
+	}
+	return rx, nil
+}
+
+func compileStatsRegexps(provider *providerJSON, field string, patterns []string, prefix, suffix string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		rx, err := compileStatsRegexp(provider, field, pattern, prefix, suffix)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = rx
+	}
+	return result, nil
+}
+
+// Forget all counts
+func (collector *StatsCollector) Reset() {
+	stats := &RuleStats{
+		RulesSHA256: collector.ruleSet.SHA256,
+		Providers:   make(map[string]*ProviderStats, len(collector.providers)),
+	}
+	for name, provider := range collector.providers {
+		providerStats := &ProviderStats{
+			Rules:             map[string]*RuleHits{},
+			ReferralMarketing: map[string]int{},
+			Redirections:      map[string]int{},
+			Exceptions:        map[string]int{},
+		}
+		for _, rule := range provider.definition.Rules {
+			providerStats.Rules[rule] = &RuleHits{Keys: map[string]int{}}
+		}
+		for _, referral := range provider.definition.ReferralMarketing {
+			providerStats.ReferralMarketing[referral] = 0
+		}
+		for _, redirection := range provider.definition.Redirections {
+			providerStats.Redirections[redirection] = 0
+		}
+		for _, exception := range provider.definition.Exceptions {
+			providerStats.Exceptions[exception] = 0
+		}
+		stats.Providers[name] = providerStats
+	}
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.stats = stats
+}
+
+// Same as [ClearURL] with the providers of the rule set, counting what fired
+func (collector *StatsCollector) ClearURL(url string, keepMarketingReferrals bool) (string, error) {
+	trace, err := ClearURLWithTrace(collector.ruleSet.Providers, url, keepMarketingReferrals)
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.count(trace, err)
+	return trace.Output, err
+}
+
+// Count what happened in `trace`, holding the lock. Only what the runner recorded is
+// counted: providers that matched or were excepted on the URLs it actually cleaned,
+// and the keys each step removed.
+func (collector *StatsCollector) count(trace *CleanTrace, err error) {
+	stats := collector.stats
+	stats.URLCount++
+	if err != nil {
+		stats.ErrorCount++
+	} else if trace.Output != trace.Input {
+		stats.ChangedCount++
+	}
+	// URLs are cleaned until they stop changing: count each provider, exception and block once per URL of the corpus
+	counted := map[string]bool{}
+	once := func(parts ...string) bool {
+		key := strings.Join(parts, "\x00")
+		if counted[key] {
+			return false
+		}
+		counted[key] = true
+		return true
+	}
+	for _, match := range trace.Matches {
+		provider, providerStats := collector.providers[match.Provider], stats.Providers[match.Provider]
+		if provider == nil {
+			continue // Not from the rules JSON
+		}
+		if !match.Excepted {
+			if once("match", match.Provider) {
+				providerStats.Matches++
+			}
+			continue
+		}
+		exception := slices.IndexFunc(provider.exceptions, func(rx *regexp.Regexp) bool { return rx.MatchString(match.URL) })
+		if exception >= 0 && once("exception", match.Provider, provider.definition.Exceptions[exception]) {
+			providerStats.Exceptions[provider.definition.Exceptions[exception]]++
+		}
+	}
+	for _, step := range trace.Steps {
+		provider, providerStats := collector.providers[step.Provider], stats.Providers[step.Provider]
+		if provider == nil {
+			continue
+		}
+		switch {
+		case step.Complete:
+			if once("block", step.Provider) {
+				providerStats.Blocks++
+			}
+		case step.Redirect:
+			redirection := slices.IndexFunc(provider.redirections, func(rx *regexp.Regexp) bool { return rx.MatchString(step.Before) })
+			if redirection >= 0 {
+				providerStats.Redirections[provider.definition.Redirections[redirection]]++
+			}
+		}
+		// The runner only tells which keys the provider removed, find which of its entries did
+		for _, key := range slices.Concat(step.RemovedParameters, step.RedactedParameters) {
+			rule := slices.IndexFunc(provider.rules, func(rx *regexp.Regexp) bool { return rx.MatchString(key) })
+			if rule >= 0 {
+				hits := providerStats.Rules[provider.definition.Rules[rule]]
+				hits.Removed++
+				hits.Keys[key]++
+			}
+		}
+		for _, key := range step.ReferralParameters {
+			referral := slices.IndexFunc(provider.referralMarketing, func(rx *regexp.Regexp) bool { return rx.MatchString(key) })
+			if referral >= 0 {
+				providerStats.ReferralMarketing[provider.definition.ReferralMarketing[referral]]++
+			}
+		}
+	}
+}
+
+// Return a copy of the counts so far
+func (collector *StatsCollector) Stats() *RuleStats {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	result := *collector.stats
+	result.Providers = make(map[string]*ProviderStats, len(collector.stats.Providers))
+	for name, providerStats := range collector.stats.Providers {
+		copied := *providerStats
+		copied.Rules = make(map[string]*RuleHits, len(providerStats.Rules))
+		for rule, hits := range providerStats.Rules {
+			copied.Rules[rule] = &RuleHits{Removed: hits.Removed, Keys: maps.Clone(hits.Keys)}
+		}
+		copied.ReferralMarketing = maps.Clone(providerStats.ReferralMarketing)
+		copied.Redirections = maps.Clone(providerStats.Redirections)
+		copied.Exceptions = maps.Clone(providerStats.Exceptions)
+		result.Providers[name] = &copied
+	}
+	return &result
+}
+
+// Human readable version of the counts: providers that matched or were prevented to by
+// an exception, most matches first, then those that never matched
+func (stats *RuleStats) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%d URLs, %d changed, %d errors, with rules %s\n", stats.URLCount, stats.ChangedCount, stats.ErrorCount, stats.RulesSHA256)
+	names := slices.SortedFunc(maps.Keys(stats.Providers), func(a, b string) int {
+		return cmp.Or(cmp.Compare(stats.Providers[b].Matches, stats.Providers[a].Matches), strings.Compare(a, b))
+	})
+	neverMatched := []string{}
+	printCounts := func(label string, counts map[string]int) {
+		for _, pattern := range slices.Sorted(maps.Keys(counts)) {
+			fmt.Fprintf(&builder, "    %s %q: %d\n", label, pattern, counts[pattern])
+		}
+	}
+	for _, name := range names {
+		providerStats := stats.Providers[name]
+		excepted := 0
+		for _, count := range providerStats.Exceptions {
+			excepted += count
+		}
+		if providerStats.Matches == 0 && excepted == 0 {
+			neverMatched = append(neverMatched, name)
+			continue
+		}
+		fmt.Fprintf(&builder, "\n%s: %d matches", name, providerStats.Matches)
+		if providerStats.Blocks > 0 {
+			fmt.Fprintf(&builder, ", %d blocks", providerStats.Blocks)
+		}
+		builder.WriteString("\n")
+		for _, rule := range slices.Sorted(maps.Keys(providerStats.Rules)) {
+			hits := providerStats.Rules[rule]
+			fmt.Fprintf(&builder, "    rule %q: %d", rule, hits.Removed)
+			if len(hits.Keys) > 0 {
+				keys := []string{}
+				for _, key := range slices.Sorted(maps.Keys(hits.Keys)) {
+					keys = append(keys, fmt.Sprintf("%s=%d", key, hits.Keys[key]))
+				}
+				fmt.Fprintf(&builder, " (%s)", strings.Join(keys, ", "))
+			}
+			builder.WriteString("\n")
+		}
+		printCounts("referral", providerStats.ReferralMarketing)
+		printCounts("redirection", providerStats.Redirections)
+		printCounts("exception", providerStats.Exceptions)
+	}
+	if len(neverMatched) > 0 {
+		fmt.Fprintf(&builder, "\nNever matched: %s\n", strings.Join(neverMatched, ", "))
+	}
+	return builder.String()
+}
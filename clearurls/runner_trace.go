@@ -0,0 +1,109 @@
+package clearurls
+
+// Record what each provider did while cleaning an URL, eg: to explain a result
+
+import "slices"
+
+// What one provider did to an URL, see [CleanTrace]
+type CleanStep struct {
+	// Name of the provider
+	Provider string `json:"provider"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	// `true` if the provider matched a `redirections` entry, `After` is the redirection
+	Redirect bool `json:"redirect,omitempty"`
+	// `true` if the provider is a `completeProvider`, which leaves the URL as is
+	Complete bool `json:"complete,omitempty"`
+	// `true` if the provider's `rawRules` removed parts of the URL string, before its
+	// `rules` removed keys in a step of their own
+	RawRules bool `json:"rawRules,omitempty"`
+	// Keys removed from the query and fragment by `rules` and `referralMarketing`
+	RemovedParameters []string `json:"removedParameters,omitempty"`
+	// Keys whose values were masked instead, see [ClearURLRedacted]
+	RedactedParameters []string `json:"redactedParameters,omitempty"`
+	// Those of the removed or redacted keys also matching `referralMarketing`, which are
+	// kept when keeping marketing referrals
+	ReferralParameters []string `json:"referralParameters,omitempty"`
+}
+
+// A provider whose `urlPattern` matched an URL, see [CleanTrace]
+type ProviderMatch struct {
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	// `true` if one of the `exceptions` matched too, so the provider did not run
+	Excepted bool `json:"excepted,omitempty"`
+}
+
+// Steps taken by [ClearURLWithTrace] to clean an URL
+type CleanTrace struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	// In the order they happened. Providers that matched but removed nothing are not
+	// included, even if the URL was re-encoded: see `Matches`.
+	Steps []CleanStep `json:"steps"`
+	// Providers whose `urlPattern` matched, in the order they were tried. URLs are
+	// cleaned until they stop changing, so the same URL may appear more than once.
+	Matches []ProviderMatch `json:"matches"`
+}
+
+// Append `step`, if tracing at all
+func (trace *CleanTrace) addStep(step CleanStep) {
+	if trace != nil {
+		trace.Steps = append(trace.Steps, step)
+	}
+}
+
+// Record whether `provider` ran on `url` (`matched`), or was prevented to by its
+// `exceptions`, if tracing at all
+func (trace *CleanTrace) addMatch(provider RunnableProvider, url string, matched bool) error {
+	if trace == nil {
+		return nil
+	}
+	if !matched {
+		patternMatched, err := provider.matchURLPattern(url)
+		if err != nil || !patternMatched {
+			return err
+		}
+	}
+	trace.Matches = append(trace.Matches, ProviderMatch{Provider: provider.getName(), URL: url, Excepted: !matched})
+	return nil
+}
+
+// Return those of `keys` the provider only removes when not keeping marketing referrals
+func referralParameters(provider RunnableProvider, keys []string) ([]string, error) {
+	var referrals []string
+	for _, key := range keys {
+		filteredAnyway, err := provider.rulesKeyFilter(key, true)
+		if err != nil {
+			return nil, err
+		}
+		if !filteredAnyway {
+			referrals = append(referrals, key)
+		}
+	}
+	return referrals, nil
+}
+
+// Return the names of the providers of the steps, without duplicates, in order
+func (trace *CleanTrace) Providers() []string {
+	names := []string{}
+	for _, step := range trace.Steps {
+		if !slices.Contains(names, step.Provider) {
+			names = append(names, step.Provider)
+		}
+	}
+	return names
+}
+
+// Same as [ClearURL], also returning what each provider did. On error, the trace
+// holds the steps taken until then.
+func ClearURLWithTrace(providers []RunnableProvider, url string, keepMarketingReferrals bool) (*CleanTrace, error) {
+	return clearURLWithTrace(providers, url, &CleanOptions{KeepMarketingReferrals: keepMarketingReferrals})
+}
+
+func clearURLWithTrace(providers []RunnableProvider, url string, options *CleanOptions) (*CleanTrace, error) {
+	trace := &CleanTrace{Input: url, Steps: []CleanStep{}, Matches: []ProviderMatch{}}
+	cleaned, err := clearURL(providers, url, options, trace)
+	trace.Output = cleaned
+	return trace, err
+}
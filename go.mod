@@ -1,3 +1,5 @@
 module github.com/ddlsmurf/clearurls-go
 
 go 1.24.4
+
+require golang.org/x/net v0.50.0
@@ -3,24 +3,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
 	"fmt"
 	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/ddlsmurf/clearurls-go/clearurls"
 )
 
 func commandGenerate(source, destrinationFile string) error {
-	providers, err := clearurls.GetProvidersFromSourceArgument(source)
+	ruleSet, err := getRuleSet(source)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(os.Stderr, "Got %d providers\n", len(providers))
-	if _, err := clearurls.Compile(providers); err != nil {
-		return fmt.Errorf("Couldn't compile providers. %w", err)
-	}
-	goSource := clearurls.GenerateGoSourceCodeForProviders(providers)
+	fmt.Fprintf(os.Stderr, "Got %d providers (sha256: %s)\n", len(ruleSet.Providers), ruleSet.SHA256)
+	goSource := clearurls.GenerateGoSourceCodeForRuleSet(ruleSet, embedRulesJSON)
 	fmt.Fprintf(os.Stderr, "Writing %d bytes to %q\n", len(goSource), destrinationFile)
 	if destrinationFile == "-" {
 		fmt.Println(goSource)
@@ -29,49 +30,130 @@ func commandGenerate(source, destrinationFile string) error {
 	return os.WriteFile(destrinationFile, []byte(goSource), 0644)
 }
 
-func runCleanURLTests(providers []clearurls.RunnableProvider) int {
-	failed := 0
-	runCleanURLTest := func(url, expected string) {
-		fmt.Fprintf(os.Stderr, "\n- Test        %q\n", url)
-		cleaned, err := clearurls.ClearURL(providers, url, false)
-		if err != nil {
-			panic(err)
-		}
-		if expected != cleaned {
-			fmt.Fprintf(os.Stderr, "   FAIL  Got: %q\n    Expected: %q\n", cleaned, expected)
-			failed++
-		} else {
-			fmt.Fprintf(os.Stderr, "   pass  Got: %q\n", cleaned)
-		}
+// Fixtures used by `mini_tests`, and `test` when no fixtures file is given
+//
+//go:embed testdata/regression_fixtures.json
+var regressionFixtures []byte
+
+func readFixtures(fixturesFile string) ([]clearurls.URLFixture, error) {
+	if fixturesFile == "" {
+		return clearurls.ReadURLFixtures(bytes.NewReader(regressionFixtures))
+	}
+	if fixturesFile == "-" {
+		return clearurls.ReadURLFixtures(os.Stdin)
+	}
+	file, err := os.Open(fixturesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return clearurls.ReadURLFixtures(file)
+}
+
+func runCleanURLTests(providers []clearurls.RunnableProvider, fixtures []clearurls.URLFixture) int {
+	report := clearurls.RunURLFixtures(providers, fixtures)
+	fmt.Fprint(os.Stderr, report)
+	return report.Failed
+}
+
+func commandTest(source, fixturesFile string) error {
+	fixtures, err := readFixtures(fixturesFile)
+	if err != nil {
+		return err
+	}
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return err
+	}
+	report := clearurls.RunURLFixtures(ruleSet.Providers, fixtures)
+	switch {
+	case outputJUnit:
+		err = report.WriteJUnit(os.Stdout, "cleanurls "+source)
+	case outputJSON:
+		err = printJSON(report)
+	default:
+		fmt.Print(report)
 	}
-	runCleanURLTest("https://amazon.com?zoup=com&keywords=truc", "https://amazon.com?zoup=com")
-	runCleanURLTest("https://amazon.com?zoup=com&keywords=truc#bidule=truc&keywords=ohno", "https://amazon.com?zoup=com#bidule=truc")
-	runCleanURLTest("https://indeed.com?zoup=com&yclid=truc", "https://indeed.com?zoup=com")
-	runCleanURLTest("https://indeed.com?yclid=truc", "https://indeed.com")
-	runCleanURLTest("https://indeed.com/rc/clk?from=com&keywords=truc", "https://indeed.com/rc/clk?from=com&keywords=truc") // exception
-	runCleanURLTest("https://google.com/plop?adurl=https%3A%2F%2Famazon.com%3Fzoup%3Dcom", "https://amazon.com?zoup=com")
-	runCleanURLTest("https://google.com/plop?adurl=https%3A%2F%2Famazon.com%3Fzoup%3Dcom%26keywords%3Dtruc", "https://amazon.com?zoup=com")
-	if failed > 0 {
-		fmt.Fprintf(os.Stderr, "\n  => %d failed\n", failed)
+	if err == nil && report.Failed > 0 {
+		err = fmt.Errorf("%d of %d tests failed", report.Failed, len(report.Results))
 	}
-	return failed
+	return err
+}
+
+func commandRecord(source, urlsFile, fixturesFile string) error {
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return err
+	}
+	urls, err := readURLCorpus(urlsFile)
+	if err != nil {
+		return err
+	}
+	fixtures, err := clearurls.RecordURLFixtures(ruleSet, urls, false)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Recorded %d fixtures with rules %s\n", len(fixtures), ruleSet.SHA256)
+	ndjson := strings.HasSuffix(fixturesFile, ".ndjson")
+	if fixturesFile == "-" {
+		return clearurls.WriteURLFixtures(os.Stdout, fixtures, ndjson)
+	}
+	file, err := os.Create(fixturesFile)
+	if err != nil {
+		return err
+	}
+	if err := clearurls.WriteURLFixtures(file, fixtures, ndjson); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func commandCheck(source, fixturesFile string) error {
+	fixtures, err := readFixtures(fixturesFile)
+	if err != nil {
+		return err
+	}
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return err
+	}
+	// Without a cache, failures are reported without the rules diff explaining them
+	snapshots, _ := clearurls.GetSnapshotsFromSourceArgument(source)
+	report := clearurls.CheckURLFixtures(ruleSet, fixtures, snapshots)
+	switch {
+	case outputJUnit:
+		err = report.WriteJUnit(os.Stdout, "cleanurls "+source)
+	case outputJSON:
+		err = printJSON(report)
+	default:
+		fmt.Print(report)
+	}
+	if err == nil && report.Failed > 0 {
+		err = fmt.Errorf("%d of %d fixtures changed", report.Failed, len(report.Results))
+	}
+	return err
 }
 
 func commandMiniTests(source string) error {
-	providers, err := clearurls.GetProvidersFromSourceArgument(source)
+	fixtures, err := readFixtures("")
+	if err != nil {
+		return err
+	}
+	providers, err := getProviders(source)
 	if err != nil {
 		return err
 	}
 	wasCompiled := providers[0].IsCompiled()
 	fmt.Fprintf(os.Stderr, "Got %d providers (compiled: %v)\n", len(providers), wasCompiled)
-	fails := runCleanURLTests(providers)
+	fails := runCleanURLTests(providers, fixtures)
 	if !wasCompiled {
 		compiledProviders, err := clearurls.Compile(providers)
 		if err != nil {
 			return err
 		}
 		fmt.Fprintf(os.Stderr, "\nRe-running tests on compiled providers (%v)\n\n", compiledProviders[0].IsCompiled())
-		fails += runCleanURLTests(compiledProviders)
+		fails += runCleanURLTests(compiledProviders, fixtures)
 		if clearurls.GenerateGoSourceCodeForProviders(providers) != clearurls.GenerateGoSourceCodeForProviders(compiledProviders) {
 			fmt.Fprintf(os.Stderr, "\nFail: Source compiled from json and from compiled providers didn't match")
 			fails++
@@ -87,7 +169,7 @@ func commandMiniTests(source string) error {
 		fmt.Fprintf(os.Stderr, "\nSkip: Hardcoded providers not included in this version\n")
 	} else {
 		fmt.Fprintf(os.Stderr, "\nGot %d hardcoded providers (compiled: %v)\n", len(providers), wasCompiled)
-		fails += runCleanURLTests(providers)
+		fails += runCleanURLTests(providers, fixtures)
 	}
 	if fails > 0 {
 		return fmt.Errorf("Got %d fail(s)\n", fails)
@@ -95,6 +177,277 @@ func commandMiniTests(source string) error {
 	return nil
 }
 
+// Set by the `--json` flag, for commands that can print JSON instead of text
+var outputJSON = false
+
+// Set by the `--junit` flag, for commands that can print JUnit XML instead of text
+var outputJUnit = false
+
+// Set by the `--embed-rules` flag, for `generate`
+var embedRulesJSON = false
+
+// Set by the `--overrides <file>` flag, for commands that clean URLs
+var overridesFile = ""
+
+// Set by each `--overlay <file>` flag, for commands reading rules
+var overlayFiles []string
+
+// Return the rule set of a source argument, with the overlays of `--overlay` applied
+func getRuleSet(source string) (*clearurls.RuleSet, error) {
+	ruleSet, err := clearurls.GetRuleSetFromSourceArgument(source)
+	if err != nil || len(overlayFiles) == 0 {
+		return ruleSet, err
+	}
+	overlays := make([]*clearurls.Overlay, len(overlayFiles))
+	for i, path := range overlayFiles {
+		if overlays[i], err = clearurls.ReadOverlayFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return ruleSet.WithOverlays(overlays...)
+}
+
+// Same as [getRuleSet], for the providers only
+func getProviders(source string) ([]clearurls.RunnableProvider, error) {
+	if len(overlayFiles) == 0 {
+		return clearurls.GetProvidersFromSourceArgument(source)
+	}
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return nil, err
+	}
+	return ruleSet.Providers, nil
+}
+
+// Read the overrides of `--overrides`, `nil` if not given, checking they name
+// providers of `providers`
+func readOverrides(providers []clearurls.RunnableProvider) (*clearurls.Overrides, error) {
+	if overridesFile == "" {
+		return nil, nil
+	}
+	file, err := os.Open(overridesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	overrides, err := clearurls.ReadOverrides(file)
+	if err == nil {
+		err = overrides.CheckProviders(providers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", overridesFile, err)
+	}
+	return overrides, nil
+}
+
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func commandInfo(source string) error {
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return err
+	}
+	return printJSON(ruleSet)
+}
+
+func commandDiff(oldSource, newSource string) error {
+	oldRules, err := clearurls.GetRuleSetFromSourceArgument(oldSource) // Without overlays, to compare with them
+	if err != nil {
+		return err
+	}
+	newRules, err := getRuleSet(newSource)
+	if err != nil {
+		return err
+	}
+	diff, err := clearurls.DiffRuleSets(oldRules, newRules)
+	if err != nil {
+		return err
+	}
+	if outputJSON {
+		return printJSON(diff)
+	}
+	fmt.Print(diff)
+	return nil
+}
+
+// Lint the rules of a source argument, with the overlays of `--overlay` applied
+func lintSource(source string) (*clearurls.LintReport, error) {
+	if len(overlayFiles) == 0 {
+		return clearurls.LintSourceArgument(source)
+	}
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return nil, err
+	}
+	return ruleSet.Lint()
+}
+
+func commandLint(source string) error {
+	report, err := lintSource(source)
+	if err != nil {
+		return err
+	}
+	if outputJSON {
+		err = printJSON(report)
+	} else {
+		fmt.Print(report)
+	}
+	if err == nil && report.ErrorCount > 0 {
+		err = fmt.Errorf("%d errors in rules from %q", report.ErrorCount, source)
+	}
+	return err
+}
+
+// Read URLs from `corpusFile`, or stdin if `-`
+func readURLCorpus(corpusFile string) ([]string, error) {
+	if corpusFile == "-" {
+		return clearurls.ReadURLCorpus(os.Stdin)
+	}
+	corpus, err := os.Open(corpusFile)
+	if err != nil {
+		return nil, err
+	}
+	defer corpus.Close()
+	return clearurls.ReadURLCorpus(corpus)
+}
+
+func commandStats(source, corpusFile string) error {
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return err
+	}
+	urls, err := readURLCorpus(corpusFile)
+	if err != nil {
+		return err
+	}
+	collector, err := clearurls.NewStatsCollector(ruleSet)
+	if err != nil {
+		return err
+	}
+	for _, url := range urls {
+		if _, err := collector.ClearURL(url, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %q: %v\n", url, err)
+		}
+	}
+	if outputJSON {
+		return printJSON(collector.Stats())
+	}
+	fmt.Print(collector.Stats())
+	return nil
+}
+
+func commandSuggest(source, corpusFile string) error {
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return err
+	}
+	urls, err := readURLCorpus(corpusFile)
+	if err != nil {
+		return err
+	}
+	suggestions := clearurls.SuggestRules(ruleSet.Providers, urls, clearurls.DefaultSuggestOptions)
+	if outputJSON {
+		return printJSON(suggestions)
+	}
+	fmt.Fprint(os.Stderr, suggestions)
+	rulesJSON, err := suggestions.RulesJSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(rulesJSON))
+	return nil
+}
+
+func commandImpact(oldSource, newSource, corpusFile string) error {
+	oldRules, err := clearurls.GetRuleSetFromSourceArgument(oldSource) // Without overlays, to compare with them
+	if err != nil {
+		return err
+	}
+	newRules, err := getRuleSet(newSource)
+	if err != nil {
+		return err
+	}
+	urls, err := readURLCorpus(corpusFile)
+	if err != nil {
+		return err
+	}
+	report := clearurls.MeasureImpact(oldRules, newRules, urls, false)
+	if outputJSON {
+		return printJSON(report)
+	}
+	fmt.Print(report)
+	return nil
+}
+
+func commandSnapshots(source string) error {
+	snapshots, err := clearurls.GetSnapshotsFromSourceArgument(source)
+	if err != nil {
+		return err
+	}
+	list, err := snapshots.List()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range list {
+		pinned := " "
+		if snapshot.Pinned {
+			pinned = "*"
+		}
+		fmt.Printf("%s %s  %s  %s\n", pinned, snapshot.SHA256, snapshot.FetchedAt.Format(time.RFC3339), snapshot.SourceURL)
+	}
+	return nil
+}
+
+func commandPin(source, sum string) error {
+	snapshots, err := clearurls.GetSnapshotsFromSourceArgument(source)
+	if err != nil {
+		return err
+	}
+	pinned, err := snapshots.Pin(sum)
+	if err != nil {
+		return err
+	}
+	printPinned(pinned)
+	return nil
+}
+
+func commandUnpin(source string) error {
+	snapshots, err := clearurls.GetSnapshotsFromSourceArgument(source)
+	if err != nil {
+		return err
+	}
+	if err := snapshots.Unpin(); err != nil {
+		return err
+	}
+	printPinned(nil)
+	return nil
+}
+
+func commandRollback(source string) error {
+	snapshots, err := clearurls.GetSnapshotsFromSourceArgument(source)
+	if err != nil {
+		return err
+	}
+	pinned, err := snapshots.Rollback()
+	if err != nil {
+		return err
+	}
+	printPinned(pinned)
+	return nil
+}
+
+func printPinned(pinned *clearurls.SnapshotInfo) {
+	if pinned == nil {
+		fmt.Fprintf(os.Stderr, "Unpinned, serving the latest rules\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "Pinned %s (%s)\n", pinned.SHA256, pinned.FetchedAt.Format(time.RFC3339))
+	}
+}
+
 func readStdinByLine(gotLine func(line string) error) error {
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
@@ -109,12 +462,17 @@ func readStdinByLine(gotLine func(line string) error) error {
 }
 
 func commandClean(source, urlToClean string, includeReferralMarketingParams bool) error {
-	providers, err := clearurls.GetProvidersFromSourceArgument(source)
+	providers, err := getProviders(source)
+	if err != nil {
+		return err
+	}
+	overrides, err := readOverrides(providers)
 	if err != nil {
 		return err
 	}
+	options := clearurls.CleanOptions{KeepMarketingReferrals: includeReferralMarketingParams, Overrides: overrides}
 	processLine := func(line string) error {
-		cleaned, err := clearurls.ClearURL(providers, line, includeReferralMarketingParams)
+		cleaned, err := clearurls.ClearURLWithOptions(providers, line, options)
 		if err != nil {
 			return err
 		}
@@ -129,6 +487,80 @@ func commandClean(source, urlToClean string, includeReferralMarketingParams bool
 	return nil
 }
 
+// Environment variable holding the salt of `redact`, when no salt file is given
+const redactionSaltEnv = "CLEANURLS_REDACTION_SALT"
+
+// Read the salt of `redact` from `saltFile`, without trailing newlines, or else from
+// the environment, so that it doesn't show in the list of processes
+func readRedactionSalt(saltFile string) ([]byte, error) {
+	if saltFile == "" {
+		return []byte(os.Getenv(redactionSaltEnv)), nil
+	}
+	salt, err := os.ReadFile(saltFile)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(salt, "\r\n"), nil
+}
+
+func commandRedact(source, urlToClean, saltFile string) error {
+	providers, err := getProviders(source)
+	if err != nil {
+		return err
+	}
+	overrides, err := readOverrides(providers)
+	if err != nil {
+		return err
+	}
+	salt, err := readRedactionSalt(saltFile)
+	if err != nil {
+		return err
+	}
+	options := clearurls.CleanOptions{Redaction: &clearurls.Redaction{Salt: salt}, Overrides: overrides}
+	processLine := func(line string) error {
+		redacted, err := clearurls.ClearURLWithOptions(providers, line, options)
+		if err != nil {
+			return err
+		}
+		fmt.Println(redacted)
+		return nil
+	}
+	if urlToClean == "-" {
+		return readStdinByLine(processLine)
+	}
+	return processLine(urlToClean)
+}
+
+func commandAnalyze(source, urlToAnalyze string) error {
+	ruleSet, err := getRuleSet(source)
+	if err != nil {
+		return err
+	}
+	overrides, err := readOverrides(ruleSet.Providers)
+	if err != nil {
+		return err
+	}
+	options := clearurls.CleanOptions{Overrides: overrides}
+	// One analysis per line on stdin, as for `clean`
+	encoder := json.NewEncoder(os.Stdout)
+	processLine := func(line string) error {
+		analysis, err := clearurls.AnalyzeURL(ruleSet.Providers, line, options)
+		if err != nil {
+			return err
+		}
+		if outputJSON {
+			return encoder.Encode(analysis)
+		}
+		fmt.Print(analysis)
+		return nil
+	}
+	if urlToAnalyze == "-" {
+		return readStdinByLine(processLine)
+	}
+	encoder.SetIndent("", "  ")
+	return processLine(urlToAnalyze)
+}
+
 type commandType struct {
 	name           string
 	argsHelp, help string
@@ -152,7 +584,11 @@ var commands = []*commandType{
 		help: "" +
 			"Apply ClearURL process to an URL.\n" +
 			"  - `source` can be the same as `generate`, or `hardcoded` if available\n" +
-			"  - `url` can be the url to clean, or `-` to process each line on stdin\n",
+			"  - `url` can be the url to clean, or `-` to process each line on stdin\n" +
+			"  - `--overrides <file>` reads keys to keep, hosts to leave as is, and where to keep\n" +
+			"    referral marketing from a JSON file,\n" +
+			"    eg: {\"keepKeysByHost\": {\"wiki.example.com\": [\"ref\"]}, \"excludedDomains\": [\"example.org\"]}\n" +
+			"    (also for `cleanKeepingReferrals`, `redact` and `analyze`)\n",
 		minArgs: 2,
 		maxArgs: 2,
 		run:     func(args []string) error { return commandClean(args[0], args[1], false) },
@@ -166,16 +602,187 @@ var commands = []*commandType{
 		maxArgs: 2,
 		run:     func(args []string) error { return commandClean(args[0], args[1], true) },
 	},
+	{
+		name:     "redact",
+		argsHelp: "<source> <url or '-'> [salt_file]",
+		help: "" +
+			"Same as `clean`, but keep tracking parameters with their values replaced by `redacted`,\n" +
+			"or if a salt is given, by a hash of the value salted with it, eg: to count distinct values\n" +
+			"  - the salt is read from `salt_file`, or else from $" + redactionSaltEnv + "\n",
+		minArgs: 2,
+		maxArgs: 3,
+		run: func(args []string) error {
+			args = append(args, "")
+			return commandRedact(args[0], args[1], args[2])
+		},
+	},
+	{
+		name:     "analyze",
+		argsHelp: "<source> <url or '-'> [--json]",
+		help: "" +
+			"Same as `clean`, but print the tracking found instead of the cleaned URL: parameters that\n" +
+			"would be removed and by which provider, redirections that would be followed, and blocks\n",
+		minArgs: 2,
+		maxArgs: 2,
+		run:     func(args []string) error { return commandAnalyze(args[0], args[1]) },
+	},
 	{
 		name:     "generate",
-		argsHelp: "<source> <destination_file>",
+		argsHelp: "<source> <destination_file> [--overlay <file>...] [--embed-rules]",
 		help: "" +
 			"Download CleanURL's JSON and generate hardoded data in GO source.\n" +
-			"  - `source` can be '{github,gitlab}[:path_to_cache_file[:max_age_in_minutes]]'",
+			"  - `source` can be '{github,gitlab,auto}[:path_to_cache_file[:max_age_in_minutes]]'\n" +
+			"    with mirrors to fail over between separated by '|', eg: 'gitlab|github'\n" +
+			"    and '@' as cache file for the default user cache, eg: 'github:@:60'\n" +
+			"    or the path of a local rules file, eg: './custom_rules.json'\n" +
+			"    followed by overlay files to apply, eg: 'github:@:60?overlay=./a.json&overlay=./b.json'\n" +
+			"  - `--overlay <file>` patches the rules with an overlay file, in order if repeated,\n" +
+			"    eg: to include our patches in hardcoded data. Also for other commands reading rules,\n" +
+			"    where it applies to `source`, or `new_source` for `diff` and `impact`, after the\n" +
+			"    overlays of the source itself\n" +
+			"  - `--embed-rules` also embeds the rules JSON, roughly doubling the size of the output,\n" +
+			"    so that `hardcoded` can be used with `diff`, `stats`, `lint` and overlays",
 		minArgs: 2,
 		maxArgs: 2,
 		run:     func(args []string) error { return commandGenerate(args[0], args[1]) },
 	},
+	{
+		name:     "info",
+		argsHelp: "<source>",
+		help: "" +
+			"Print as JSON where the rules of `source` come from: SHA-256, source URL, fetch time, counts\n",
+		minArgs: 1,
+		maxArgs: 1,
+		run:     func(args []string) error { return commandInfo(args[0]) },
+	},
+	{
+		name:     "diff",
+		argsHelp: "<old_source> <new_source> [--json]",
+		help: "" +
+			"Print the providers added, removed, or with modified fields from `old_source` to `new_source`,\n" +
+			"eg: 'diff hardcoded github' before generating new hardcoded data\n",
+		minArgs: 2,
+		maxArgs: 2,
+		run:     func(args []string) error { return commandDiff(args[0], args[1]) },
+	},
+	{
+		name:     "lint",
+		argsHelp: "<source> [--json]",
+		help: "" +
+			"Check the rules of `source` for invalid patterns, duplicate providers and rules, and likely\n" +
+			"mistakes, eg: 'lint ./custom_rules.json'. Fails if there are errors, not just warnings\n",
+		minArgs: 1,
+		maxArgs: 1,
+		run:     func(args []string) error { return commandLint(args[0]) },
+	},
+	{
+		name:     "stats",
+		argsHelp: "<source> <corpus_file> [--json]",
+		help: "" +
+			"Clean each URL of `corpus_file` (one per line, `-` for stdin) with `source`, and print how many\n" +
+			"times each provider matched or blocked, and each of its rules, redirections and exceptions fired\n",
+		minArgs: 2,
+		maxArgs: 2,
+		run:     func(args []string) error { return commandStats(args[0], args[1]) },
+	},
+	{
+		name:     "suggest",
+		argsHelp: "<source> <corpus_file> [--json]",
+		help: "" +
+			"Clean each URL of `corpus_file` (one per line, `-` for stdin) with `source`, find the keys left\n" +
+			"that look like tracking parameters, and print candidate providers removing them, to review.\n" +
+			"With `--json`, print the scores of each key instead\n",
+		minArgs: 2,
+		maxArgs: 2,
+		run:     func(args []string) error { return commandSuggest(args[0], args[1]) },
+	},
+	{
+		name:     "impact",
+		argsHelp: "<old_source> <new_source> <corpus_file> [--json]",
+		help: "" +
+			"Clean each URL of `corpus_file` (one per line, `-` for stdin) with both sources, and print\n" +
+			"those with a different result, grouped by the provider responsible\n",
+		minArgs: 3,
+		maxArgs: 3,
+		run:     func(args []string) error { return commandImpact(args[0], args[1], args[2]) },
+	},
+	{
+		name:     "snapshots",
+		argsHelp: "<source>",
+		help: "" +
+			"List the previous versions of the rules kept in the cache of `source`, most recent first.\n" +
+			"The one marked with `*` is pinned. Use a version with '<source>:<cache_file>@<sha256>'.\n",
+		minArgs: 1,
+		maxArgs: 1,
+		run:     func(args []string) error { return commandSnapshots(args[0]) },
+	},
+	{
+		name:     "pin",
+		argsHelp: "<source> <sha256>",
+		help: "" +
+			"Serve the version `sha256` (or a prefix of it) from the cache of `source` instead of the latest\n",
+		minArgs: 2,
+		maxArgs: 2,
+		run:     func(args []string) error { return commandPin(args[0], args[1]) },
+	},
+	{
+		name:     "unpin",
+		argsHelp: "<source>",
+		help:     "Serve the latest rules from the cache of `source` again\n",
+		minArgs:  1,
+		maxArgs:  1,
+		run:      func(args []string) error { return commandUnpin(args[0]) },
+	},
+	{
+		name:     "rollback",
+		argsHelp: "<source>",
+		help:     "Pin the version preceding the one served from the cache of `source`\n",
+		minArgs:  1,
+		maxArgs:  1,
+		run:      func(args []string) error { return commandRollback(args[0]) },
+	},
+	{
+		name:     "test",
+		argsHelp: "<source> [fixtures_file] [--json|--junit]",
+		help: "" +
+			"Clean the URLs of `fixtures_file` with `source` and compare with their expected result.\n" +
+			"  - `fixtures_file` is a JSON array, or one JSON object per line, of\n" +
+			"    {\"name\": \"...\", \"input\": \"...\", \"expected\": \"...\", \"blocked\": false, \"keepMarketingReferrals\": false}\n" +
+			"    where `blocked` checks the input matches a completeProvider instead of `expected`,\n" +
+			"    `-` for stdin, and defaults to a small built-in regression suite\n" +
+			"  - Fails if any test fails\n",
+		minArgs: 1,
+		maxArgs: 2,
+		run: func(args []string) error {
+			fixturesFile := ""
+			if len(args) > 1 {
+				fixturesFile = args[1]
+			}
+			return commandTest(args[0], fixturesFile)
+		},
+	},
+	{
+		name:     "record",
+		argsHelp: "<source> <urls_file> <fixtures_file>",
+		help: "" +
+			"Clean each URL of `urls_file` (one per line, `-` for stdin) with `source`, and write the results\n" +
+			"as fixtures for `test` and `check`, with the providers responsible and the rules SHA-256.\n" +
+			"  - `fixtures_file` is written one fixture per line if it ends with '.ndjson', `-` for stdout\n",
+		minArgs: 3,
+		maxArgs: 3,
+		run:     func(args []string) error { return commandRecord(args[0], args[1], args[2]) },
+	},
+	{
+		name:     "check",
+		argsHelp: "<source> <fixtures_file> [--json|--junit]",
+		help: "" +
+			"Same as `test` with fixtures from `record`, also printing for each changed URL the changes to the\n" +
+			"providers involved since recording. Those need the recorded rules in the cache of `source`,\n" +
+			"eg: 'check github:@ fixtures.json'\n",
+		minArgs: 2,
+		maxArgs: 2,
+		run:     func(args []string) error { return commandCheck(args[0], args[1]) },
+	},
 	{
 		name:     "mini_tests", // Some mini unit-ish tests to run on real data
 		argsHelp: "[source]",
@@ -217,6 +824,26 @@ func runCommandFromArguments(args []string) error {
 	if slices.Contains(args, "--help") {
 		return ArgsErrorJustPrintHelp
 	}
+	for flag, value := range map[string]*bool{"--json": &outputJSON, "--junit": &outputJUnit, "--embed-rules": &embedRulesJSON} {
+		if i := slices.Index(args, flag); i >= 0 {
+			*value = true
+			args = slices.Delete(args, i, i+1)
+		}
+	}
+	for i := slices.Index(args, "--overlay"); i >= 0; i = slices.Index(args, "--overlay") {
+		if i+1 >= len(args) {
+			return NewInvalidArgumentsError("Missing file after --overlay")
+		}
+		overlayFiles = append(overlayFiles, args[i+1])
+		args = slices.Delete(args, i, i+2)
+	}
+	if i := slices.Index(args, "--overrides"); i >= 0 {
+		if i+1 >= len(args) {
+			return NewInvalidArgumentsError("Missing file after --overrides")
+		}
+		overridesFile = args[i+1]
+		args = slices.Delete(args, i, i+2)
+	}
 	commandName := args[1]
 	args = args[2:]
 	for _, command := range commands {